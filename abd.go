@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("abd", scopeGlobal, true, kstatCollector{"abdstats", abdStats})
+	registerCollector("metaslab", scopeGlobal, true, kstatCollector{"metaslab_stats", metaslabStats})
+}
+
+var abdStats = []kstatMetric{
+	{"struct_size", prometheus.NewDesc("zfs_abd_struct_bytes", "Bytes used by the ABD structures themselves", nil, nil), prometheus.GaugeValue},
+	{"scatter_cnt", prometheus.NewDesc("zfs_abd_scatter_count", "Number of scatter ABDs", nil, nil), prometheus.GaugeValue},
+	{"scatter_data_size", prometheus.NewDesc("zfs_abd_scatter_bytes", "Bytes of data stored in scatter ABDs", nil, nil), prometheus.GaugeValue},
+	{"scatter_chunk_waste", prometheus.NewDesc("zfs_abd_scatter_chunk_waste_bytes", "Bytes allocated for scatter ABDs but not used to store data", nil, nil), prometheus.GaugeValue},
+	{"scatter_page_alloc_retry", prometheus.NewDesc("zfs_abd_scatter_page_alloc_retries_total", "Number of retried page allocations for scatter ABDs", nil, nil), prometheus.CounterValue},
+	{"scatter_sg_table_retry", prometheus.NewDesc("zfs_abd_scatter_sg_table_retries_total", "Number of retried scatterlist table allocations", nil, nil), prometheus.CounterValue},
+	{"linear_cnt", prometheus.NewDesc("zfs_abd_linear_count", "Number of linear ABDs", nil, nil), prometheus.GaugeValue},
+	{"linear_data_size", prometheus.NewDesc("zfs_abd_linear_bytes", "Bytes of data stored in linear ABDs", nil, nil), prometheus.GaugeValue},
+}
+
+// The kernel doesn't count metaslab loads and unloads, metaslab_stats only counts allocation
+// slow paths.
+var metaslabStats = []kstatMetric{
+	{"trace_over_limit", prometheus.NewDesc("zfs_metaslab_trace_over_limit_total", "Number of allocation traces dropped because zfs_metaslab_trace_max_entries was reached", nil, nil), prometheus.CounterValue},
+	{"reload_tree", prometheus.NewDesc("zfs_metaslab_reload_tree_total", "Number of times the size-sorted free space tree of a metaslab was incomplete and had to be rebuilt", nil, nil), prometheus.CounterValue},
+	{"too_many_tries", prometheus.NewDesc("zfs_metaslab_too_many_tries_total", "Number of allocations which gave up after trying too many metaslabs", nil, nil), prometheus.CounterValue},
+	{"try_hard", prometheus.NewDesc("zfs_metaslab_try_hard_total", "Number of allocations which had to fall back to trying hard", nil, nil), prometheus.CounterValue},
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// stringMap is a flag.Value for repeatable key=value pairs.
+type stringMap map[string]string
+
+func (m stringMap) String() string {
+	var pairs []string
+	for k, v := range m {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (m stringMap) Set(val string) error {
+	return parsePairs(val, func(key, value string) error {
+		m[key] = value
+		return nil
+	})
+}
+
+// parsePairs splits a flag value made of comma-separated key=value pairs and calls set for each
+// of them. It is shared by all flags which set something per pool.
+func parsePairs(val string, set func(key, value string) error) error {
+	for _, pair := range strings.Split(val, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("expected key=value, got %q", pair)
+		}
+		if err := set(parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var poolAliases = make(stringMap)
+
+func init() {
+	flag.Var(poolAliases, "pool-alias", "Use an alias instead of the pool name as zpool label (pool=alias, can be repeated or comma-separated)")
+}
+
+// poolAlias returns the value of the zpool label for the given pool.
+func poolAlias(poolName string) string {
+	if alias, ok := poolAliases[poolName]; ok {
+		return alias
+	}
+	return poolName
+}
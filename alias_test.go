@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStringMapSet(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    stringMap
+		wantErr bool
+	}{
+		{"tank=fast", stringMap{"tank": "fast"}, false},
+		{"tank=fast,backup=slow", stringMap{"tank": "fast", "backup": "slow"}, false},
+		{"tank=a=b", stringMap{"tank": "a=b"}, false},
+		{"tank=", stringMap{"tank": ""}, false},
+		{"tank", stringMap{}, true},
+		{"=fast", stringMap{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			m := make(stringMap)
+			err := m.Set(tt.val)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) returned %v, want error: %v", tt.val, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(m, tt.want) {
+				t.Errorf("Set(%q) = %v, want %v", tt.val, m, tt.want)
+			}
+		})
+	}
+}
+
+func TestPoolDurationsSet(t *testing.T) {
+	tests := []struct {
+		val     string
+		want    poolDurations
+		wantErr bool
+	}{
+		{"tank=5m", poolDurations{"tank": 5 * time.Minute}, false},
+		{"tank=5m,backup=1h", poolDurations{"tank": 5 * time.Minute, "backup": time.Hour}, false},
+		{"tank", poolDurations{}, true},
+		{"tank=often", poolDurations{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.val, func(t *testing.T) {
+			d := make(poolDurations)
+			err := d.Set(tt.val)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) returned %v, want error: %v", tt.val, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(d, tt.want) {
+				t.Errorf("Set(%q) = %v, want %v", tt.val, d, tt.want)
+			}
+		})
+	}
+}
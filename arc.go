@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("arc", scopeGlobal, true, kstatCollector{"arcstats", arcStats})
+}
+
+var arcStats = []kstatMetric{
+	{"hits", prometheus.NewDesc("zfs_arc_hits_total", "Number of requests served from the ARC", nil, nil), prometheus.CounterValue},
+	{"misses", prometheus.NewDesc("zfs_arc_misses_total", "Number of requests not served from the ARC", nil, nil), prometheus.CounterValue},
+	{"demand_data_hits", prometheus.NewDesc("zfs_arc_demand_data_hits_total", "Number of demand data requests served from the ARC", nil, nil), prometheus.CounterValue},
+	{"demand_data_misses", prometheus.NewDesc("zfs_arc_demand_data_misses_total", "Number of demand data requests not served from the ARC", nil, nil), prometheus.CounterValue},
+	{"demand_metadata_hits", prometheus.NewDesc("zfs_arc_demand_metadata_hits_total", "Number of demand metadata requests served from the ARC", nil, nil), prometheus.CounterValue},
+	{"demand_metadata_misses", prometheus.NewDesc("zfs_arc_demand_metadata_misses_total", "Number of demand metadata requests not served from the ARC", nil, nil), prometheus.CounterValue},
+	{"prefetch_data_hits", prometheus.NewDesc("zfs_arc_prefetch_data_hits_total", "Number of prefetch data requests served from the ARC", nil, nil), prometheus.CounterValue},
+	{"prefetch_data_misses", prometheus.NewDesc("zfs_arc_prefetch_data_misses_total", "Number of prefetch data requests not served from the ARC", nil, nil), prometheus.CounterValue},
+	{"prefetch_metadata_hits", prometheus.NewDesc("zfs_arc_prefetch_metadata_hits_total", "Number of prefetch metadata requests served from the ARC", nil, nil), prometheus.CounterValue},
+	{"prefetch_metadata_misses", prometheus.NewDesc("zfs_arc_prefetch_metadata_misses_total", "Number of prefetch metadata requests not served from the ARC", nil, nil), prometheus.CounterValue},
+	{"size", prometheus.NewDesc("zfs_arc_size_bytes", "Current size of the ARC", nil, nil), prometheus.GaugeValue},
+	{"c", prometheus.NewDesc("zfs_arc_target_size_bytes", "Size the ARC is currently trying to reach", nil, nil), prometheus.GaugeValue},
+	{"c_min", prometheus.NewDesc("zfs_arc_min_size_bytes", "Minimum size the ARC target is kept at", nil, nil), prometheus.GaugeValue},
+	{"c_max", prometheus.NewDesc("zfs_arc_max_size_bytes", "Maximum size the ARC target is allowed to grow to", nil, nil), prometheus.GaugeValue},
+	{"mru_size", prometheus.NewDesc("zfs_arc_mru_size_bytes", "Size of the most recently used list of the ARC", nil, nil), prometheus.GaugeValue},
+	{"mfu_size", prometheus.NewDesc("zfs_arc_mfu_size_bytes", "Size of the most frequently used list of the ARC", nil, nil), prometheus.GaugeValue},
+	{"compressed_size", prometheus.NewDesc("zfs_arc_compressed_size_bytes", "Compressed size of the data stored in the ARC", nil, nil), prometheus.GaugeValue},
+	{"uncompressed_size", prometheus.NewDesc("zfs_arc_uncompressed_size_bytes", "Uncompressed size of the data stored in the ARC", nil, nil), prometheus.GaugeValue},
+	// The L2ARC stats are part of the arcstats and cover all cache devices together
+	{"l2_hits", prometheus.NewDesc("zfs_l2arc_hits_total", "Number of ARC misses served from the L2ARC", nil, nil), prometheus.CounterValue},
+	{"l2_misses", prometheus.NewDesc("zfs_l2arc_misses_total", "Number of ARC misses not served from the L2ARC", nil, nil), prometheus.CounterValue},
+	{"l2_size", prometheus.NewDesc("zfs_l2arc_size_bytes", "Uncompressed size of the data stored in the L2ARC", nil, nil), prometheus.GaugeValue},
+	{"l2_asize", prometheus.NewDesc("zfs_l2arc_allocated_bytes", "Space allocated on the cache devices by the L2ARC", nil, nil), prometheus.GaugeValue},
+	{"l2_read_bytes", prometheus.NewDesc("zfs_l2arc_read_bytes_total", "Bytes read from the cache devices", nil, nil), prometheus.CounterValue},
+	{"l2_write_bytes", prometheus.NewDesc("zfs_l2arc_written_bytes_total", "Bytes written to the cache devices", nil, nil), prometheus.CounterValue},
+	{"l2_rw_clash", prometheus.NewDesc("zfs_l2arc_rw_clash_total", "Number of L2ARC reads skipped because the block was being written at the same time", nil, nil), prometheus.CounterValue},
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("bookmark", scopeDataset, false, subCollectorFunc((*zfsCollector).collectBookmarks))
+}
+
+var datasetBookmarkCount = prometheus.NewDesc("zfs_dataset_bookmark_count", "Number of bookmarks of the dataset", []string{"name", "zpool"}, nil)
+
+// countBookmarksProgram returns the number of bookmarks of every filesystem and volume in the
+// pool. There is no ioctl listing bookmarks one at a time like snapshots, so this is done in a
+// channel program.
+const countBookmarksProgram = `
+args = ...
+counts = {}
+function walk(ds)
+	local n = 0
+	for _ in zfs.list.bookmarks(ds) do
+		n = n + 1
+	end
+	counts[ds] = n
+	for child in zfs.list.children(ds) do
+		walk(child)
+	end
+end
+walk(args["pool"])
+return counts
+`
+
+// collectBookmarks exports the number of bookmarks of each dataset. The zfs.list.bookmarks
+// iterator it uses first shipped in OpenZFS 2.0, so it is disabled by default.
+//
+// The program walks the whole pool within the instruction and memory limits of all channel
+// programs, which pools with many datasets can exceed. Channel programs run atomically, so the
+// kernel then returns an error instead of a partial table. This counts as a scrape error of the
+// bookmarks collector and no bookmark metrics are exported for the pool.
+func (c *zfsCollector) collectBookmarks(ch chan<- prometheus.Metric, pool *poolData) error {
+	out, err := c.zfs.ChannelProgram(pool.name, countBookmarksProgram, map[string]interface{}{"pool": pool.name})
+	if err == nil {
+		if _, ok := out["return"].(map[string]interface{}); !ok {
+			err = errors.New("channel program returned no table")
+		}
+	}
+	if err != nil {
+		c.scrapeErrors.WithLabelValues("bookmarks").Inc()
+		level.Warn(logger).Log("msg", "Failed to count bookmarks", "pool", pool.name, "err", err)
+		return err
+	}
+	counts := make(map[string]float64)
+	flattenChannelProgramResult(counts, "", out["return"])
+	for name, n := range counts {
+		if !datasetFilter.match(name) {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(datasetBookmarkCount, prometheus.GaugeValue, n, name, pool.label)
+	}
+	return nil
+}
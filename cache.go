@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// poolDurations is a flag.Value for repeatable pool=duration pairs.
+type poolDurations map[string]time.Duration
+
+func (d poolDurations) String() string {
+	var pairs []string
+	for pool, dur := range d {
+		pairs = append(pairs, pool+"="+dur.String())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (d poolDurations) Set(val string) error {
+	return parsePairs(val, func(pool, value string) error {
+		dur, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration for pool %v: %w", pool, err)
+		}
+		d[pool] = dur
+		return nil
+	})
+}
+
+var (
+	cacheTTL        = flag.Duration("cache-ttl", 0, "Reuse the metrics of a pool for the given time instead of querying the kernel on every scrape (0 disables caching)")
+	datasetCacheTTL = make(poolDurations)
+)
+
+func init() {
+	flag.Var(datasetCacheTTL, "dataset-cache-ttl", "Reuse dataset and snapshot metrics of a pool for the given time instead of collecting them on every scrape (pool=duration, can be repeated or comma-separated)")
+}
+
+type cachedMetrics struct {
+	metrics []prometheus.Metric
+	expires time.Time
+}
+
+// metricCache caches the metrics emitted by expensive collection functions.
+type metricCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedMetrics
+}
+
+func newMetricCache() *metricCache {
+	return &metricCache{entries: make(map[string]cachedMetrics)}
+}
+
+// collect sends the cached metrics for key if they haven't expired yet. Otherwise it calls fn and
+// caches the metrics it emits for ttl, but only if fn reports success so a transient failure isn't
+// served for the whole ttl. A zero ttl disables caching. It returns false if fn failed.
+func (c *metricCache) collect(ch chan<- prometheus.Metric, key string, ttl time.Duration, fn func(ch chan<- prometheus.Metric) bool) bool {
+	if ttl <= 0 {
+		return fn(ch)
+	}
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	success := true
+	if !ok || time.Now().After(entry.expires) {
+		entry = cachedMetrics{expires: time.Now().Add(ttl)}
+		entry.metrics = gatherMetrics(func(ch chan<- prometheus.Metric) { success = fn(ch) })
+		c.mu.Lock()
+		if success {
+			c.entries[key] = entry
+		} else {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+	}
+	for _, m := range entry.metrics {
+		ch <- m
+	}
+	return success
+}
+
+// retain evicts the entries of all keys for which keep returns false, like those of pools which
+// have been exported.
+func (c *metricCache) retain(keep func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if !keep(key) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// gatherMetrics calls fn and returns all metrics it emits.
+func gatherMetrics(fn func(ch chan<- prometheus.Metric)) []prometheus.Metric {
+	var metrics []prometheus.Metric
+	collected := make(chan prometheus.Metric)
+	go func() {
+		fn(collected)
+		close(collected)
+	}()
+	for m := range collected {
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+// lazyGauge is a gauge whose value is only computed when it is written. Metrics relative to the
+// current time use it so they stay correct while they are cached.
+type lazyGauge struct {
+	desc        *prometheus.Desc
+	value       func() float64
+	labelValues []string
+}
+
+func (g lazyGauge) Desc() *prometheus.Desc {
+	return g.desc
+}
+
+func (g lazyGauge) Write(m *dto.Metric) error {
+	return prometheus.MustNewConstMetric(g.desc, prometheus.GaugeValue, g.value(), g.labelValues...).Write(m)
+}
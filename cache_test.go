@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var testDesc = prometheus.NewDesc("test_value", "Value for testing", nil, nil)
+
+// cachedValue collects key from the cache with a fresh value and success and returns the value
+// which was sent.
+func cachedValue(t *testing.T, c *metricCache, key string, value float64, success bool) float64 {
+	t.Helper()
+	metrics := gatherMetrics(func(ch chan<- prometheus.Metric) {
+		c.collect(ch, key, time.Hour, func(ch chan<- prometheus.Metric) bool {
+			ch <- prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, value)
+			return success
+		})
+	})
+	if len(metrics) != 1 {
+		t.Fatalf("got %d metrics, want 1", len(metrics))
+	}
+	var m dto.Metric
+	if err := metrics[0].Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestMetricCache(t *testing.T) {
+	c := newMetricCache()
+	if v := cachedValue(t, c, "pool", 1, false); v != 1 {
+		t.Errorf("first collection returned %v, want 1", v)
+	}
+	if v := cachedValue(t, c, "pool", 2, true); v != 2 {
+		t.Errorf("failed collection was cached, got %v, want 2", v)
+	}
+	if v := cachedValue(t, c, "pool", 3, true); v != 2 {
+		t.Errorf("successful collection wasn't cached, got %v, want 2", v)
+	}
+	c.retain(func(key string) bool { return key != "pool" })
+	if v := cachedValue(t, c, "pool", 4, true); v != 4 {
+		t.Errorf("evicted entry was still served, got %v, want 4", v)
+	}
+}
+
+func TestLazyGauge(t *testing.T) {
+	var value float64
+	g := lazyGauge{testDesc, func() float64 { return value }, nil}
+	for _, want := range []float64{1, 2} {
+		value = want
+		var m dto.Metric
+		if err := g.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		if got := m.GetGauge().GetValue(); got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+// A pool whose stats couldn't be read must be collected again on the next scrape.
+func TestPoolCacheFailure(t *testing.T) {
+	defer func(ttl time.Duration) { *cacheTTL = ttl }(*cacheTTL)
+	*cacheTTL = time.Hour
+	src := failingPool()
+	c := newCollector(src)
+	want := poolScrapeSuccessHeader + `zfs_pool_scrape_success{zpool="testpool"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "zfs_pool_scrape_success"); err != nil {
+		t.Error(err)
+	}
+	src.statsErr = nil
+	want = poolScrapeSuccessHeader + `zfs_pool_scrape_success{zpool="testpool"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "zfs_pool_scrape_success"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("channel_program", scopePoolIO, true, subCollectorFunc((*zfsCollector).collectChannelProgram))
+}
+
+var (
+	channelProgramPath = flag.String("channel-program", "", "Path to a ZFS channel program (Lua) which is run read-only against every pool on each scrape. Numeric values in the returned table are exposed as zfs_channel_program_result.")
+)
+
+const (
+	// Same as the defaults used by `zfs program`
+	channelProgramInstrLimit = 10_000_000
+	channelProgramMemLimit   = 10 * 1024 * 1024
+)
+
+var (
+	channelProgramResult = prometheus.NewDesc("zfs_channel_program_result", "Numeric value returned by the user-supplied channel program", []string{"name", "zpool"}, nil)
+)
+
+var channelProgram string
+
+func loadChannelProgram() {
+	if *channelProgramPath == "" {
+		return
+	}
+	prog, err := os.ReadFile(*channelProgramPath)
+	if err != nil {
+		level.Error(logger).Log("msg", "Failed to read channel program", "err", err)
+		os.Exit(1)
+	}
+	channelProgram = string(prog)
+}
+
+// collectChannelProgram runs the configured channel program against the given pool. Channel
+// programs are always run in open context, see zfsSource.
+func (c *zfsCollector) collectChannelProgram(ch chan<- prometheus.Metric, pool *poolData) error {
+	poolName := pool.name
+	if channelProgram == "" {
+		return nil
+	}
+	poolLabel := pool.label
+	out, err := c.zfs.ChannelProgram(poolName, channelProgram, map[string]interface{}{"pool": poolName})
+	if err != nil {
+		c.scrapeErrors.WithLabelValues("channel_program").Inc()
+		level.Warn(logger).Log("msg", "Channel program failed", "pool", poolName, "err", err)
+		return err
+	}
+	values := make(map[string]float64)
+	flattenChannelProgramResult(values, "", out["return"])
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ch <- prometheus.MustNewConstMetric(channelProgramResult, prometheus.GaugeValue, values[name], name, poolLabel)
+	}
+	return nil
+}
+
+// flattenChannelProgramResult collects all numeric values in a (possibly nested) channel program
+// result. Nested table keys are joined with dots, non-numeric values are ignored.
+func flattenChannelProgramResult(values map[string]float64, prefix string, val interface{}) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			flattenChannelProgramResult(values, strings.TrimPrefix(prefix+"."+k, "."), child)
+		}
+	case int64:
+		values[prefix] = float64(v)
+	case uint64:
+		values[prefix] = float64(v)
+	case bool:
+		if v {
+			values[prefix] = 1
+		} else {
+			values[prefix] = 0
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFlattenChannelProgramResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		result interface{}
+		want   map[string]float64
+	}{
+		{"number", int64(-3), map[string]float64{"": -3}},
+		{"table", map[string]interface{}{"a": int64(1), "b": uint64(2), "c": true, "d": false}, map[string]float64{"a": 1, "b": 2, "c": 1, "d": 0}},
+		{
+			name: "nested",
+			result: map[string]interface{}{
+				"tank": map[string]interface{}{
+					"snapshots": int64(12),
+					"data":      map[string]interface{}{"snapshots": int64(3)},
+				},
+			},
+			want: map[string]float64{"tank.snapshots": 12, "tank.data.snapshots": 3},
+		},
+		{"strings are ignored", map[string]interface{}{"a": "b", "c": int64(1)}, map[string]float64{"c": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]float64)
+			flattenChannelProgramResult(got, "", tt.result)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
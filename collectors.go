@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolData is what per-pool sub-collectors get to work with.
+type poolData struct {
+	name     string
+	label    string // value of the zpool label, the pool's alias if it has one
+	stats    map[string]interface{}
+	vdevTree map[string]interface{}
+}
+
+// A subCollector collects one group of metrics for the given collector. Global sub-collectors are
+// called once per scrape with a nil pool, all others once for each pool. Errors are expected to
+// already be logged and counted in the collector's scrapeErrors, they only mark the sub-collector
+// as failed.
+type subCollector interface {
+	collect(c *zfsCollector, ch chan<- prometheus.Metric, pool *poolData) error
+}
+
+// subCollectorFunc adapts a function, usually a zfsCollector method expression, to a subCollector.
+type subCollectorFunc func(c *zfsCollector, ch chan<- prometheus.Metric, pool *poolData) error
+
+func (f subCollectorFunc) collect(c *zfsCollector, ch chan<- prometheus.Metric, pool *poolData) error {
+	return f(c, ch, pool)
+}
+
+// collectorScope determines when a sub-collector is run.
+type collectorScope int
+
+const (
+	// scopeGlobal sub-collectors run once per scrape.
+	scopeGlobal collectorScope = iota
+	// scopePool sub-collectors run for every pool.
+	scopePool
+	// scopePoolIO sub-collectors read from the pool, they are skipped for suspended pools.
+	scopePoolIO
+	// scopeDataset sub-collectors read from the pool and are cached for -dataset-cache-ttl.
+	scopeDataset
+)
+
+type registeredCollector struct {
+	name      string
+	scope     collectorScope
+	enabled   *bool
+	collector subCollector
+}
+
+var subCollectors []registeredCollector
+
+// registerCollector registers a sub-collector together with its -collector.<name> flag. It must
+// be called from init functions so the flag exists before flags are parsed.
+func registerCollector(name string, scope collectorScope, defaultEnabled bool, c subCollector) {
+	enabled := flag.Bool("collector."+name, defaultEnabled, fmt.Sprintf("Enable the %s collector", name))
+	subCollectors = append(subCollectors, registeredCollector{name, scope, enabled, c})
+}
+
+// runCollectors runs all enabled sub-collectors of the given scope. It returns false if any of
+// them failed.
+func (c *zfsCollector) runCollectors(ch chan<- prometheus.Metric, scope collectorScope, pool *poolData, times *scrapeTimes) bool {
+	success := true
+	for _, sc := range subCollectors {
+		if sc.scope != scope || !*sc.enabled {
+			continue
+		}
+		times.run(sc.name, func() error {
+			err := sc.collector.collect(c, ch, pool)
+			if err != nil {
+				success = false
+			}
+			return err
+		})
+	}
+	return success
+}
+
+var (
+	collectorDuration    = prometheus.NewDesc("zfs_collector_duration_seconds", "Time the sub-collector took during the last scrape, summed over all pools for per-pool sub-collectors", []string{"collector"}, nil)
+	collectorLastSuccess = prometheus.NewDesc("zfs_collector_last_success_timestamp_seconds", "Time of the last scrape in which the sub-collector ran without errors", []string{"collector"}, nil)
+)
+
+// scrapeTimes tracks the time spent in each sub-collector during a single scrape and whether it
+// failed. Per-pool sub-collectors run concurrently for different pools, so it is locked.
+type scrapeTimes struct {
+	mu       sync.Mutex
+	duration map[string]time.Duration
+	failed   map[string]bool
+}
+
+func newScrapeTimes() *scrapeTimes {
+	return &scrapeTimes{
+		duration: make(map[string]time.Duration),
+		failed:   make(map[string]bool),
+	}
+}
+
+// run runs fn as the named sub-collector and records how long it took and whether it failed.
+func (t *scrapeTimes) run(name string, fn func() error) {
+	start := time.Now()
+	err := fn()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.duration[name] += time.Since(start)
+	if err != nil {
+		t.failed[name] = true
+	}
+}
+
+// fail marks the named sub-collector as failed without it having run to completion.
+func (t *scrapeTimes) fail(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failed[name] = true
+}
+
+// collectTimes exports the sub-collector durations of a scrape and updates the last success time
+// of all sub-collectors which ran without errors. Sub-collectors which didn't run because their
+// metrics were cached only keep their previous success time.
+func (c *zfsCollector) collectTimes(ch chan<- prometheus.Metric, t *scrapeTimes) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, d := range t.duration {
+		ch <- prometheus.MustNewConstMetric(collectorDuration, prometheus.GaugeValue, d.Seconds(), name)
+		if !t.failed[name] {
+			c.lastSuccess[name] = now
+		}
+	}
+	for name, ts := range c.lastSuccess {
+		ch <- prometheus.MustNewConstMetric(collectorLastSuccess, prometheus.GaugeValue, float64(ts.UnixNano())/1e9, name)
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("dataset", scopeDataset, true, subCollectorFunc((*zfsCollector).collectDatasets))
+}
+
+var datasetLabels = []string{"name", "type", "zpool"}
+
+var datasetProps = []propMetric{
+	{"used", prometheus.NewDesc("zfs_dataset_used_bytes", "Space used by the dataset and all its descendants", datasetLabels, nil), 1},
+	{"available", prometheus.NewDesc("zfs_dataset_available_bytes", "Space available to the dataset and all its children", datasetLabels, nil), 1},
+	{"referenced", prometheus.NewDesc("zfs_dataset_referenced_bytes", "Space referenced by the dataset, possibly shared with other datasets", datasetLabels, nil), 1},
+	{"logicalused", prometheus.NewDesc("zfs_dataset_logicalused_bytes", "Space used by the dataset and all its descendants before compression", datasetLabels, nil), 1},
+	{"logicalreferenced", prometheus.NewDesc("zfs_dataset_logicalreferenced_bytes", "Space referenced by the dataset before compression", datasetLabels, nil), 1},
+	{"written", prometheus.NewDesc("zfs_dataset_written_bytes", "Space referenced by the dataset which was written since its latest snapshot", datasetLabels, nil), 1},
+	{"usedbysnapshots", prometheus.NewDesc("zfs_dataset_usedbysnapshots_bytes", "Space which would be freed if all snapshots of the dataset were destroyed", datasetLabels, nil), 1},
+	{"quota", prometheus.NewDesc("zfs_dataset_quota_bytes", "Quota of the filesystem and its descendants, 0 if there is none", datasetLabels, nil), 1},
+	{"reservation", prometheus.NewDesc("zfs_dataset_reservation_bytes", "Space guaranteed to the dataset and its descendants, 0 if there is no reservation", datasetLabels, nil), 1},
+	{"refreservation", prometheus.NewDesc("zfs_dataset_refreservation_bytes", "Space guaranteed to the dataset itself, 0 if there is no reservation", datasetLabels, nil), 1},
+	{"compressratio", prometheus.NewDesc("zfs_dataset_compressratio", "Compression ratio achieved for the space referenced by the dataset", datasetLabels, nil), 100},
+	{"readonly", prometheus.NewDesc("zfs_dataset_readonly", "Whether the dataset is readonly", datasetLabels, nil), 1},
+	{"canmount", prometheus.NewDesc("zfs_dataset_canmount", "canmount property of the filesystem (0=off, 1=on, 2=noauto)", datasetLabels, nil), 1},
+}
+
+var datasetCompression = prometheus.NewDesc("zfs_dataset_compression_info", "Compression algorithm configured for the dataset, default if it is neither set nor inherited", append(datasetLabels[:len(datasetLabels):len(datasetLabels)], "compression"), nil)
+
+// zio_compress values of the compression property, indexed by value
+var compressionNames = []string{"inherit", "on", "off", "lzjb", "empty", "gzip-1", "gzip-2", "gzip-3", "gzip-4", "gzip-5", "gzip-6", "gzip-7", "gzip-8", "gzip-9", "zle", "lz4", "zstd"}
+
+const (
+	compressZSTD  = 16
+	compressBits  = 7   // the zstd level is stored above the algorithm
+	zstdLevelFast = 102 // ZIO_ZSTD_LEVEL_FAST, the zstd-fast-N levels follow it
+)
+
+// zstdFastLevels are the accelerations of the zstd-fast-N levels, in the order of their
+// zio_zstd_levels values.
+var zstdFastLevels = []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 500, 1000}
+
+// compressionName returns the name `zfs get compression` shows for a compression property value.
+func compressionName(v uint64) string {
+	algo, zstdLevel := v&(1<<compressBits-1), v>>compressBits
+	if algo == compressZSTD && zstdLevel != 0 {
+		if zstdLevel > zstdLevelFast && zstdLevel-zstdLevelFast <= uint64(len(zstdFastLevels)) {
+			return fmt.Sprintf("zstd-fast-%d", zstdFastLevels[zstdLevel-zstdLevelFast-1])
+		}
+		return fmt.Sprintf("zstd-%d", zstdLevel)
+	}
+	if algo < uint64(len(compressionNames)) {
+		return compressionNames[algo]
+	}
+	return fmt.Sprintf("unknown_%d", v)
+}
+
+var (
+	datasetEncrypted = prometheus.NewDesc("zfs_dataset_encrypted", "Whether the dataset is encrypted", datasetLabels, nil)
+	datasetKeyStatus = prometheus.NewDesc("zfs_dataset_keystatus", "Whether the key of an encrypted dataset is loaded, the state label is available or unavailable", append(datasetLabels[:len(datasetLabels):len(datasetLabels)], "state"), nil)
+)
+
+var datasetOrigin = prometheus.NewDesc("zfs_dataset_origin_info", "Snapshot a clone was created from", append(datasetLabels[:len(datasetLabels):len(datasetLabels)], "origin"), nil)
+
+// zfs_keystatus_t values, indexed by value
+var keyStatusNames = []string{"none", "unavailable", "available"}
+
+// Properties which only exist on volumes
+var volumeProps = []propMetric{
+	{"volsize", prometheus.NewDesc("zfs_volume_size_bytes", "Logical size of the volume", []string{"name", "zpool"}, nil), 1},
+	{"volblocksize", prometheus.NewDesc("zfs_volume_blocksize_bytes", "Block size of the volume", []string{"name", "zpool"}, nil), 1},
+}
+
+// Properties which only exist on filesystems
+var filesystemProps = []propMetric{
+	{"recordsize", prometheus.NewDesc("zfs_dataset_recordsize_bytes", "Maximum block size of files in the filesystem", []string{"name", "zpool"}, nil), 1},
+}
+
+// Properties in datasetProps which volumes don't have. They are exported with the type label like
+// the others, but volumes would only get their defaults.
+var filesystemOnlyProps = map[string]bool{
+	"quota":    true,
+	"canmount": true,
+}
+
+// The kernel only returns properties which are set locally or inherited, these are the built-in
+// defaults of the exported properties which need one.
+var datasetPropDefaults = map[string]uint64{
+	"quota":          0,
+	"reservation":    0,
+	"refreservation": 0,
+	"readonly":       0,
+	"canmount":       1,
+	"recordsize":     128 * 1024,
+}
+
+// Stats from the objset kstats. These are tracked by the ZPL and zvol layers, which only create the
+// kstat while a filesystem is mounted or a volume has a device node (not with volmode=none).
+// Other datasets don't have these metrics at all.
+var datasetObjsetStats = []kstatMetric{
+	{"reads", prometheus.NewDesc("zfs_dataset_reads_total", "Number of read operations on the dataset, only present while a filesystem is mounted or a volume has a device node", datasetLabels, nil), prometheus.CounterValue},
+	{"writes", prometheus.NewDesc("zfs_dataset_writes_total", "Number of write operations on the dataset, only present while a filesystem is mounted or a volume has a device node", datasetLabels, nil), prometheus.CounterValue},
+	{"nread", prometheus.NewDesc("zfs_dataset_read_bytes_total", "Bytes read from the dataset, only present while a filesystem is mounted or a volume has a device node", datasetLabels, nil), prometheus.CounterValue},
+	{"nwritten", prometheus.NewDesc("zfs_dataset_write_bytes_total", "Bytes written to the dataset, only present while a filesystem is mounted or a volume has a device node", datasetLabels, nil), prometheus.CounterValue},
+}
+
+// collectDatasets exports the metrics of all filesystems and volumes of the given pool. Failures
+// only affecting some metrics don't stop the collection, the last one is returned.
+func (c *zfsCollector) collectDatasets(ch chan<- prometheus.Metric, pool *poolData) error {
+	poolName := pool.name
+	poolLabel := pool.label
+	mounted, lastErr := readMountedDatasets()
+	if lastErr != nil {
+		c.scrapeErrors.WithLabelValues("datasets").Inc()
+		level.Warn(logger).Log("msg", "Failed to read mount table", "err", lastErr)
+	}
+	// Mountpoints are relative to the altroot of pools imported with one
+	var altroot string
+	if mounted != nil {
+		poolProps, err := c.zfs.PoolGetProps(poolName)
+		if err != nil {
+			c.scrapeErrors.WithLabelValues("datasets").Inc()
+			level.Warn(logger).Log("msg", "Failed to get pool properties", "pool", poolName, "err", err)
+			lastErr = err
+		}
+		altroot, _ = propString(poolProps, "altroot")
+	}
+	err := c.walkDatasets(poolName, func(name string, props map[string]interface{}) bool {
+		if !datasetFilter.match(name) {
+			return true
+		}
+		// Only volumes have a volsize.
+		_, isVolume := propUint64(props, "volsize")
+		datasetType := "filesystem"
+		if isVolume {
+			datasetType = "volume"
+		}
+		compression := "default"
+		if v, ok := propUint64(props, "compression"); ok {
+			compression = compressionName(v)
+		}
+		ch <- prometheus.MustNewConstMetric(datasetCompression, prometheus.GaugeValue, 1, name, datasetType, poolLabel, compression)
+		// Only clones have an origin
+		if origin, ok := propString(props, "origin"); ok {
+			ch <- prometheus.MustNewConstMetric(datasetOrigin, prometheus.GaugeValue, 1, name, datasetType, poolLabel, origin)
+		}
+		// The kernel only reports the encryption properties of encrypted datasets
+		var encrypted float64
+		if keyStatus, ok := propUint64(props, "keystatus"); ok {
+			encrypted = 1
+			state := fmt.Sprintf("unknown_%d", keyStatus)
+			if keyStatus < uint64(len(keyStatusNames)) {
+				state = keyStatusNames[keyStatus]
+			}
+			ch <- prometheus.MustNewConstMetric(datasetKeyStatus, prometheus.GaugeValue, 1, name, datasetType, poolLabel, state)
+		}
+		ch <- prometheus.MustNewConstMetric(datasetEncrypted, prometheus.GaugeValue, encrypted, name, datasetType, poolLabel)
+		for _, p := range datasetProps {
+			if isVolume && filesystemOnlyProps[p.name] {
+				continue
+			}
+			v, ok := propUint64(props, p.name)
+			if !ok {
+				v, ok = datasetPropDefaults[p.name]
+			}
+			if ok {
+				ch <- prometheus.MustNewConstMetric(p.desc, prometheus.GaugeValue, float64(v)/p.divisor, name, datasetType, poolLabel)
+			}
+		}
+		if !isVolume && mounted != nil {
+			var isMounted float64
+			if mounted[name] {
+				isMounted = 1
+			}
+			ch <- prometheus.MustNewConstMetric(datasetMounted, prometheus.GaugeValue, isMounted, name, datasetMountpoint(name, props, altroot), poolLabel)
+		}
+		if isVolume {
+			for _, p := range volumeProps {
+				if v, ok := propUint64(props, p.name); ok {
+					ch <- prometheus.MustNewConstMetric(p.desc, prometheus.GaugeValue, float64(v)/p.divisor, name, poolLabel)
+				}
+			}
+		} else {
+			for _, p := range filesystemProps {
+				v, ok := propUint64(props, p.name)
+				if !ok {
+					v, ok = datasetPropDefaults[p.name]
+				}
+				if ok {
+					ch <- prometheus.MustNewConstMetric(p.desc, prometheus.GaugeValue, float64(v)/p.divisor, name, poolLabel)
+				}
+			}
+		}
+		// The objset kstats are named after the objset ID, so they are found without looking up where
+		// the dataset is mounted or its volume device. A missing kstat (unmounted filesystem or
+		// volume without device) is not an error.
+		if objsetID, ok := propUint64(props, "objsetid"); ok {
+			if err := c.collectKstat(ch, fmt.Sprintf("%s/objset-0x%x", poolName, objsetID), datasetObjsetStats, name, datasetType, poolLabel); err != nil {
+				lastErr = err
+			}
+		}
+		return true
+	})
+	if err != nil {
+		c.scrapeErrors.WithLabelValues("datasets").Inc()
+		level.Warn(logger).Log("msg", "Failed to list datasets", "pool", poolName, "err", err)
+		return err
+	}
+	return lastErr
+}
+
+// walkDatasets calls fn for every filesystem and volume in the given pool, starting with the pool's
+// root dataset, until fn returns false.
+func (c *zfsCollector) walkDatasets(poolName string, fn func(name string, props map[string]interface{}) bool) error {
+	props, err := c.zfs.ObjsetStats(poolName)
+	if err != nil {
+		return err
+	}
+	if !fn(poolName, props) {
+		return nil
+	}
+	_, err = c.walkChildDatasets(poolName, fn)
+	return err
+}
+
+// walkChildDatasets walks all descendants of parent, it returns false if fn stopped the walk.
+func (c *zfsCollector) walkChildDatasets(parent string, fn func(name string, props map[string]interface{}) bool) (bool, error) {
+	var cookie uint64
+	for {
+		name, next, props, err := c.zfs.DatasetListNext(parent, cookie)
+		if errors.Is(err, syscall.ESRCH) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		if !fn(name, props) {
+			return false, nil
+		}
+		if cont, err := c.walkChildDatasets(name, fn); !cont || err != nil {
+			return false, err
+		}
+		cookie = next
+	}
+}
+
+// walkSnapshots calls fn for every snapshot of the given dataset until fn returns false.
+func (c *zfsCollector) walkSnapshots(dataset string, fn func(name string, props map[string]interface{}) bool) error {
+	var cookie uint64
+	for {
+		name, next, props, err := c.zfs.SnapshotListNext(dataset, cookie)
+		if errors.Is(err, syscall.ESRCH) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !fn(name, props) {
+			return nil
+		}
+		cookie = next
+	}
+}
+
+// propUint64 returns the value of a numeric property from a ZFS property nvlist.
+func propUint64(props map[string]interface{}, name string) (uint64, bool) {
+	prop, ok := props[name].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+	v, ok := prop["value"].(uint64)
+	return v, ok
+}
+
+// propString returns the value of a string property from a ZFS property nvlist.
+func propString(props map[string]interface{}, name string) (string, bool) {
+	prop, ok := props[name].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	v, ok := prop["value"].(string)
+	return v, ok
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestCompressionName(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want string
+	}{
+		{0, "inherit"},
+		{2, "off"},
+		{15, "lz4"},
+		{16, "zstd"},
+		{16 | 3<<compressBits, "zstd-3"},
+		{16 | 19<<compressBits, "zstd-19"},
+		{16 | 103<<compressBits, "zstd-fast-1"},
+		{16 | 112<<compressBits, "zstd-fast-10"},
+		{16 | 123<<compressBits, "zstd-fast-1000"},
+		{17, "unknown_17"},
+	}
+	for _, tt := range tests {
+		if got := compressionName(tt.v); got != tt.want {
+			t.Errorf("compressionName(%d) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Indices into ddt_object_t. The kernel sums the entries up over all DDT objects of the pool, but
+// divides the sizes by the number of entries, so they are sizes per entry.
+const (
+	ddtObjectCount  = 0
+	ddtObjectDSpace = 1
+	ddtObjectMSpace = 2
+)
+
+var (
+	ddtEntries     = prometheus.NewDesc("zfs_pool_ddt_entries", "Number of entries in the pool's dedup table", []string{"zpool"}, nil)
+	ddtDiskBytes   = prometheus.NewDesc("zfs_pool_ddt_disk_bytes", "On-disk size of the pool's dedup table", []string{"zpool"}, nil)
+	ddtMemoryBytes = prometheus.NewDesc("zfs_pool_ddt_memory_bytes", "In-core size of the pool's dedup table", []string{"zpool"}, nil)
+)
+
+// collectDDT exports the dedup table statistics contained in the pool's stats. Pools which have
+// never used dedup have an empty table.
+func collectDDT(ch chan<- prometheus.Metric, stats map[string]interface{}, poolLabel string) {
+	ddo, ok := stats["ddt_object_stats"].([]uint64)
+	if !ok || len(ddo) <= ddtObjectMSpace {
+		return
+	}
+	count := float64(ddo[ddtObjectCount])
+	ch <- prometheus.MustNewConstMetric(ddtEntries, prometheus.GaugeValue, count, poolLabel)
+	ch <- prometheus.MustNewConstMetric(ddtDiskBytes, prometheus.GaugeValue, float64(ddo[ddtObjectDSpace])*count, poolLabel)
+	ch <- prometheus.MustNewConstMetric(ddtMemoryBytes, prometheus.GaugeValue, float64(ddo[ddtObjectMSpace])*count, poolLabel)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectDDT(t *testing.T) {
+	// 1000 entries of 320 bytes on disk and 180 bytes in core each
+	stats := map[string]interface{}{"ddt_object_stats": []uint64{1000, 320, 180}}
+	want := `
+# HELP zfs_pool_ddt_entries Number of entries in the pool's dedup table
+# TYPE zfs_pool_ddt_entries gauge
+zfs_pool_ddt_entries{zpool="testpool"} 1000
+# HELP zfs_pool_ddt_disk_bytes On-disk size of the pool's dedup table
+# TYPE zfs_pool_ddt_disk_bytes gauge
+zfs_pool_ddt_disk_bytes{zpool="testpool"} 320000
+# HELP zfs_pool_ddt_memory_bytes In-core size of the pool's dedup table
+# TYPE zfs_pool_ddt_memory_bytes gauge
+zfs_pool_ddt_memory_bytes{zpool="testpool"} 180000
+`
+	c := collectFunc(func(ch chan<- prometheus.Metric) { collectDDT(ch, stats, "testpool") })
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("feature", scopePool, true, subCollectorFunc((*zfsCollector).collectFeatures))
+}
+
+var poolFeature = prometheus.NewDesc("zfs_pool_feature", "Feature flags of the pool, the state label is enabled or active. Disabled features have no series at all, the value is never 0.", []string{"feature", "state", "zpool"}, nil)
+
+// collectFeatures exports the state of the pool's features from the feature stats the kernel
+// adds to the pool's stats. These contain the reference count of every enabled feature, features
+// which are referenced are active. Disabled features are not contained at all.
+func (c *zfsCollector) collectFeatures(ch chan<- prometheus.Metric, pool *poolData) error {
+	features, _ := pool.stats["feature_stats"].(map[string]interface{})
+	for guid, v := range features {
+		refcount, ok := v.(uint64)
+		if !ok {
+			continue
+		}
+		state := "enabled"
+		if refcount > 0 {
+			state = "active"
+		}
+		// Features are identified by a GUID like com.delphix:hole_birth, its last part is the name
+		// `zpool get feature@...` uses.
+		name := guid[strings.LastIndex(guid, ":")+1:]
+		ch <- prometheus.MustNewConstMetric(poolFeature, prometheus.GaugeValue, 1, name, state, pool.label)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectFeatures(t *testing.T) {
+	pool := &poolData{name: "testpool", label: "testpool", stats: map[string]interface{}{
+		"feature_stats": map[string]interface{}{
+			"com.delphix:hole_birth":     uint64(1),
+			"org.zfsonlinux:large_dnode": uint64(0),
+		},
+	}}
+	want := `
+# HELP zfs_pool_feature Feature flags of the pool, the state label is enabled or active. Disabled features have no series at all, the value is never 0.
+# TYPE zfs_pool_feature gauge
+zfs_pool_feature{feature="hole_birth",state="active",zpool="testpool"} 1
+zfs_pool_feature{feature="large_dnode",state="enabled",zpool="testpool"} 1
+`
+	c := newCollector(&fakeSource{})
+	collector := collectFunc(func(ch chan<- prometheus.Metric) { c.collectFeatures(ch, pool) })
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"regexp"
+)
+
+// regexpFlag is a flag.Value for a regular expression which has to match the whole value.
+type regexpFlag struct {
+	re *regexp.Regexp
+}
+
+func (f *regexpFlag) String() string {
+	if f.re == nil {
+		return ""
+	}
+	return f.re.String()
+}
+
+func (f *regexpFlag) Set(val string) error {
+	re, err := regexp.Compile("^(?:" + val + ")$")
+	if err != nil {
+		return err
+	}
+	f.re = re
+	return nil
+}
+
+// nameFilter selects names matching include (if set) and not matching exclude (if set).
+type nameFilter struct {
+	include regexpFlag
+	exclude regexpFlag
+}
+
+func (f *nameFilter) match(name string) bool {
+	if f.include.re != nil && !f.include.re.MatchString(name) {
+		return false
+	}
+	if f.exclude.re != nil && f.exclude.re.MatchString(name) {
+		return false
+	}
+	return true
+}
+
+var (
+	poolFilter    nameFilter
+	datasetFilter nameFilter
+)
+
+func init() {
+	flag.Var(&poolFilter.include, "pool.include", "Regular expression of pool names to collect, all pools are collected if unset")
+	flag.Var(&poolFilter.exclude, "pool.exclude", "Regular expression of pool names not to collect")
+	flag.Var(&datasetFilter.include, "dataset.include", "Regular expression of dataset names to export dataset and snapshot metrics for, all datasets if unset")
+	flag.Var(&datasetFilter.exclude, "dataset.exclude", "Regular expression of dataset names not to export dataset and snapshot metrics for")
+}
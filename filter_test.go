@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestNameFilter(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude string
+		matches          map[string]bool
+	}{
+		{
+			name:    "no filters",
+			matches: map[string]bool{"tank": true, "tank/data": true},
+		},
+		{
+			name:    "include is anchored",
+			include: "tank",
+			matches: map[string]bool{"tank": true, "tank/data": false, "mytank": false},
+		},
+		{
+			name:    "include alternatives",
+			include: "tank|backup/.*",
+			matches: map[string]bool{"tank": true, "backup/tank": true, "backup": false},
+		},
+		{
+			name:    "exclude",
+			exclude: ".*/tmp(/.*)?",
+			matches: map[string]bool{"tank": true, "tank/tmp": false, "tank/tmp/build": false, "tank/tmpfs": true},
+		},
+		{
+			name:    "exclude wins",
+			include: "tank(/.*)?",
+			exclude: "tank/scratch",
+			matches: map[string]bool{"tank/data": true, "tank/scratch": false, "backup": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var f nameFilter
+			if tt.include != "" {
+				if err := f.include.Set(tt.include); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.exclude != "" {
+				if err := f.exclude.Set(tt.exclude); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, want := range tt.matches {
+				if got := f.match(name); got != want {
+					t.Errorf("match(%q) = %v, want %v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRegexpFlagInvalid(t *testing.T) {
+	var f regexpFlag
+	if err := f.Set("tank("); err == nil {
+		t.Error("invalid regular expression was accepted")
+	}
+}
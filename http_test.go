@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMuxRoutes(t *testing.T) {
+	metricsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metrics"))
+	})
+	tests := []struct {
+		prefix string
+		path   string
+		want   int
+	}{
+		{"/", "/", http.StatusOK},
+		{"/", "/metrics", http.StatusOK},
+		{"/", "/-/ready", http.StatusOK},
+		{"/", "/other", http.StatusNotFound},
+		{"/zfs", "/zfs", http.StatusOK},
+		{"/zfs", "/zfs/", http.StatusOK},
+		{"/zfs", "/zfs/metrics", http.StatusOK},
+		{"/zfs", "/zfs/-/ready", http.StatusOK},
+		{"/zfs", "/zfs/other", http.StatusNotFound},
+		{"/zfs", "/", http.StatusNotFound},
+		{"/zfs", "/metrics", http.StatusNotFound},
+		{"/zfs/", "/zfs/", http.StatusOK},
+	}
+	defer func(prefix string) { *routePrefix = prefix }(*routePrefix)
+	for _, tt := range tests {
+		t.Run(tt.prefix+" "+tt.path, func(t *testing.T) {
+			*routePrefix = tt.prefix
+			mux := newMux(newCollector(testPool(vdevStateHealthy)), metricsHandler)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET %s returned %d, want %d", tt.path, rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestLandingPage(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		path     string
+		want     int
+		wantLink string
+	}{
+		{"/", "/", http.StatusOK, `<a href="/metrics">`},
+		{"/", "/index.html", http.StatusNotFound, ""},
+		{"/zfs", "/zfs", http.StatusOK, `<a href="/zfs/metrics">`},
+		{"/zfs", "/zfs/", http.StatusOK, `<a href="/zfs/metrics">`},
+		{"/zfs", "/zfs/index.html", http.StatusNotFound, ""},
+		{"/zfs", "/zfs//", http.StatusNotFound, ""},
+	}
+	defer func(prefix string) { *routePrefix = prefix }(*routePrefix)
+	for _, tt := range tests {
+		t.Run(tt.prefix+" "+tt.path, func(t *testing.T) {
+			*routePrefix = tt.prefix
+			rec := httptest.NewRecorder()
+			landingPage(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("GET %s returned %d, want %d", tt.path, rec.Code, tt.want)
+			}
+			if body := rec.Body.String(); !strings.Contains(body, tt.wantLink) {
+				t.Errorf("GET %s returned %q, which doesn't contain %q", tt.path, body, tt.wantLink)
+			}
+		})
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		flag   time.Duration
+		header string
+		want   time.Duration
+	}{
+		{"no header", 5 * time.Second, "", 5 * time.Second},
+		{"no header and no timeout", 0, "", 0},
+		{"header shorter than flag", 30 * time.Second, "10", 9500 * time.Millisecond},
+		{"flag shorter than header", 5 * time.Second, "10", 5 * time.Second},
+		{"header without flag", 0, "2.5", 2 * time.Second},
+		{"header shorter than offset", 0, "0.25", 250 * time.Millisecond},
+		{"invalid header", 5 * time.Second, "soon", 5 * time.Second},
+		{"zero header", 5 * time.Second, "0", 5 * time.Second},
+	}
+	defer func(timeout, offset time.Duration) { *scrapeTimeout, *timeoutOffset = timeout, offset }(*scrapeTimeout, *timeoutOffset)
+	*timeoutOffset = 500 * time.Millisecond
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*scrapeTimeout = tt.flag
+			r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.header != "" {
+				r.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", tt.header)
+			}
+			if got := requestTimeout(r); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const kstatRoot = "/proc/spl/kstat/zfs"
+
+// kstat type identifiers as printed by SPL (KSTAT_DATA_*)
+const (
+	kstatDataChar   = "0"
+	kstatDataString = "7"
+)
+
+// errNotNamedKstat is returned by readKstat for kstats which are not in the named (name type data)
+// format, like the raw txgs or multihost history kstats or the headerless state kstat.
+var errNotNamedKstat = errors.New("not a named kstat")
+
+// kstat is a parsed named kstat as exposed by SPL under /proc/spl/kstat.
+type kstat struct {
+	values  map[string]float64
+	strings map[string]string
+}
+
+// readKstat reads and parses the named kstat at the given path relative to kstatRoot.
+func readKstat(name string) (*kstat, error) {
+	f, err := os.Open(filepath.Join(kstatRoot, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	k, err := parseKstat(f)
+	if err != nil && err != errNotNamedKstat {
+		return nil, fmt.Errorf("kstat %v: %w", name, err)
+	}
+	return k, err
+}
+
+// parseKstat parses a named kstat in the format SPL prints it in.
+func parseKstat(r io.Reader) (*kstat, error) {
+	k := kstat{
+		values:  make(map[string]float64),
+		strings: make(map[string]string),
+	}
+	s := bufio.NewScanner(r)
+	// The first two lines are the kstat header and the column names. Some kstats, like the
+	// per-pool state and guid ones on OpenZFS 2.x, consist of a single line without any header.
+	for i := 0; i < 2; i++ {
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return nil, err
+			}
+			return nil, errNotNamedKstat
+		}
+	}
+	if strings.Join(strings.Fields(s.Text()), " ") != "name type data" {
+		return nil, errNotNamedKstat
+	}
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[1] {
+		case kstatDataString:
+			k.strings[fields[0]] = strings.Join(fields[2:], " ")
+		case kstatDataChar:
+		default:
+			if len(fields) != 3 {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %v: %w", fields[0], err)
+			}
+			k.values[fields[0]] = v
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+// kstatMetric maps a value of a named kstat to a metric.
+type kstatMetric struct {
+	name      string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+}
+
+// collectKstat reads the named kstat and exports all given metrics present in it. Missing kstats
+// (for example on older ZFS versions) are silently ignored.
+func (c *zfsCollector) collectKstat(ch chan<- prometheus.Metric, name string, metrics []kstatMetric, labelValues ...string) error {
+	stats, err := readKstat(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		c.scrapeErrors.WithLabelValues("kstat").Inc()
+		level.Warn(logger).Log("msg", "Failed to read kstat", "kstat", name, "err", err)
+		return err
+	}
+	for _, m := range metrics {
+		if v, ok := stats.values[m.name]; ok {
+			ch <- prometheus.MustNewConstMetric(m.desc, m.valueType, v, labelValues...)
+		}
+	}
+	return nil
+}
+
+// kstatCollector is a global sub-collector exporting the given metrics from a named kstat.
+type kstatCollector struct {
+	name    string
+	metrics []kstatMetric
+}
+
+func (k kstatCollector) collect(c *zfsCollector, ch chan<- prometheus.Metric, _ *poolData) error {
+	return c.collectKstat(ch, k.name, k.metrics)
+}
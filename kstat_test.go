@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKstat(t *testing.T) {
+	tests := []struct {
+		name    string
+		kstat   string
+		want    *kstat
+		wantErr error
+	}{
+		{
+			name: "named",
+			kstat: `15 1 0x01 4 1088 4907442420 2097396421768
+name                            type data
+scattered_buffers               4    204
+linear_cnt                      4    18446744073709551615
+struct_size                     4    2048
+`,
+			want: &kstat{
+				values:  map[string]float64{"scattered_buffers": 204, "linear_cnt": 18446744073709551615, "struct_size": 2048},
+				strings: map[string]string{},
+			},
+		},
+		{
+			name: "strings and chars",
+			kstat: `28 1 0x01 3 840 5437395012 2097396421768
+name                            type data
+dataset_name                    7    tank/my data
+bogus                           0    x
+writes                          4    12
+`,
+			want: &kstat{
+				values:  map[string]float64{"writes": 12},
+				strings: map[string]string{"dataset_name": "tank/my data"},
+			},
+		},
+		{
+			name: "raw",
+			kstat: `18 0 0x01 1 208 5396211284 2097396421768
+txg      birth            state ndirty       nread        nwritten     reads    writes   otime        qtime        wtime        stime
+`,
+			wantErr: errNotNamedKstat,
+		},
+		{
+			name:    "headerless",
+			kstat:   "ONLINE\n",
+			wantErr: errNotNamedKstat,
+		},
+		{
+			name:    "empty",
+			wantErr: errNotNamedKstat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseKstat(strings.NewReader(tt.kstat))
+			if err != tt.wantErr {
+				t.Fatalf("got error %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKstatErrors(t *testing.T) {
+	for _, kstat := range []string{
+		"15 1 0x01 4 1088 4907442420 2097396421768\nname type data\nhits 4 many\n",
+	} {
+		if _, err := parseKstat(strings.NewReader(kstat)); err == nil {
+			t.Errorf("parsing %q succeeded, want an error", kstat)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+
+	kitlog "github.com/go-kit/log"
+	"github.com/prometheus/common/promlog"
+)
+
+var (
+	logLevel  = &promlog.AllowedLevel{}
+	logFormat = &promlog.AllowedFormat{}
+)
+
+// logger is set up from the -log.* flags at startup.
+var logger kitlog.Logger = kitlog.NewNopLogger()
+
+func init() {
+	logLevel.Set("info")
+	logFormat.Set("logfmt")
+	flag.Var(logLevel, "log.level", "Only log messages with the given severity or above (debug, info, warn, error)")
+	flag.Var(logFormat, "log.format", "Output format of log messages (logfmt, json)")
+}
+
+func setupLogger() {
+	logger = promlog.New(&promlog.Config{Level: logLevel, Format: logFormat})
+}
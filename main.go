@@ -1,21 +1,54 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"html"
 	"math"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"git.dolansoft.org/lorenz/go-zfs/ioctl"
+	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 var (
-	listenAddr = flag.String("listen-addr", ":9700", "Address the ZFS exporter should listen on")
-	versionOpt = flag.Bool("version", false, "Show version and exit")
+	listenAddr      = flag.String("listen-addr", ":9700", "Address the ZFS exporter should listen on, unix:/path/to/socket for a Unix socket")
+	socketMode      = flag.String("listen-socket-mode", "0660", "Permissions of the Unix socket if listening on one")
+	webConfig       = flag.String("web.config.file", "", "Path to a web configuration file enabling TLS and/or basic authentication (see exporter-toolkit)")
+	versionOpt      = flag.Bool("version", false, "Show version and exit")
+	routePrefix     = flag.String("web.route-prefix", "/", "Prefix under which all HTTP endpoints are served")
+	metricsPath     = flag.String("web.telemetry-path", "/metrics", "Path under which metrics are exposed, relative to the route prefix")
+	once            = flag.Bool("once", false, "Collect metrics once, print them to stdout in text format and exit")
+	metricPrefix    = flag.String("metric.prefix", "zfs_", "Prefix of the names of all metrics of the ZFS collector, replaces the default zfs_ and has to end with _. Metrics which aren't collected by it, like zfs_exporter_build_info and zfs_exporter_http_requests_total, keep their names.")
+	denylist        = flag.String("metric-denylist", "", "Regular expression of metric names which are not exported")
+	scrapeTimeout   = flag.Duration("scrape-timeout", 0, "Maximum time spent collecting pools, pools which take longer are skipped and zfs_up is set to 0 (0 disables the timeout). The timeout Prometheus sends with each scrape is used instead if it is shorter.")
+	timeoutOffset   = flag.Duration("scrape-timeout-offset", 500*time.Millisecond, "Subtracted from the timeout Prometheus sends with each scrape to leave time for sending the metrics")
+	poolConcurrency = flag.Int("pool-concurrency", 4, "Maximum number of pools collected in parallel")
+	zfsDevPath      = flag.String("zfs-dev-path", "", "Path to the ZFS control device, /dev/zfs if empty")
+	noExtStats      = flag.Bool("no-extended-stats", false, "Don't export the extended vdev stats (queue lengths and histograms) at all")
+	noHistos        = flag.Bool("no-histograms", false, "Don't export latency and I/O size histograms from the extended vdev stats")
+	vdevMetrics     = flag.String("vdev.metrics", "", "Comma-separated names of the basic vdev stats to export (like space_allocated_bytes,errors for zfs_vdev_space_allocated_bytes and zfs_vdev_errors), all if empty")
+	vdevLabelByGUID = flag.Bool("vdev.label-by-guid", false, "Use the GUID instead of the device path as vdev label of leaf vdevs, so their series survive device renames")
 )
 
 type stat struct {
@@ -23,11 +56,16 @@ type stat struct {
 	d         string
 	dimension string
 	variants  []string
+	enum      []string             // names of the values of an enum stat, exported in the dimension label with value 1
+	t         prometheus.ValueType // defaults to gauge
 	desc      *prometheus.Desc
+	offset    int
 }
 
 var (
 	zioNames = []string{"null", "read", "write", "free", "claim", "ioctl"}
+	// vdev_initializing_state_t and vdev_trim_state_t share the same values
+	actionStateNames = []string{"none", "active", "canceled", "suspended", "complete"}
 )
 
 var vdevStats = []stat{
@@ -39,46 +77,246 @@ var vdevStats = []stat{
 	{n: "space_deflated_capacity_bytes", d: "deflated capacity in bytes"},
 	{n: "devsize_replaceable", d: "replaceable device size"},
 	{n: "devsize_expandable", d: "expandable device size"},
-	{n: "ops", d: "I/O operations", dimension: "type", variants: zioNames},
-	{n: "bytes", d: "bytes processed", dimension: "type", variants: zioNames},
-	{n: "errors", d: "errors encountered", dimension: "type", variants: []string{"read", "write", "checksum", "initialize"}},
-	{n: "self_healed_bytes", d: "bytes self-healed"},
+	{n: "ops", d: "I/O operations", dimension: "type", variants: zioNames, t: prometheus.CounterValue},
+	{n: "bytes", d: "bytes processed", dimension: "type", variants: zioNames, t: prometheus.CounterValue},
+	{n: "errors", d: "errors encountered", dimension: "type", variants: []string{"read", "write", "checksum", "initialize"}, t: prometheus.CounterValue},
+	{n: "self_healed_bytes", d: "bytes self-healed", t: prometheus.CounterValue},
 	{}, // Skip weird removed stat
 	{n: "scan_processed_bytes", d: "bytes scanned"},
 	{n: "fragmentation", d: "fragmentation"},
 	{n: "initialize_processed_bytes", d: "bytes already initialized"},
 	{n: "initialize_estimated_bytes", d: "estimated total number of bytes to initialize"},
-	{n: "initialize_state", d: "initialize state (see initialize_state_t)"}, // TODO: fix
-	{n: "initialize_action_time", d: "initialize time"},
+	{n: "initialize_state", d: "initialize state", dimension: "state", enum: actionStateNames},
+	{n: "initialize_action_timestamp_seconds", d: "time of the last initialize state change as Unix timestamp"},
 	{n: "checkpoint_space_bytes", d: "checkpoint space in bytes"},
 	{n: "resilver_deferred", d: "resilver deferred"},
-	{n: "slow_ios", d: "slow I/O operations"},
-	{n: "trim_errors", d: "trim errors"},
+	{n: "slow_ios", d: "slow I/O operations", t: prometheus.CounterValue},
+	{n: "trim_errors", d: "trim errors", t: prometheus.CounterValue},
 	{n: "trim_unsupported", d: "doesn't support TRIM"},
 	{n: "trim_processed_bytes", d: "TRIMmed bytes"},
 	{n: "trim_estimated_bytes", d: "estimated bytes to TRIM"},
-	{n: "trim_state", d: "trim state"},
-	{n: "trim_action_time", d: "trim time"},
+	{n: "trim_state", d: "trim state", dimension: "state", enum: actionStateNames},
+	{n: "trim_action_timestamp_seconds", d: "time of the last TRIM state change as Unix timestamp"},
 	{n: "rebuild_processed_bytes", d: "bytes already rebuilt"},
 	{n: "ashift_configured", d: "configured ashift"},
 	{n: "ashift_logical", d: "logical ashift"},
 	{n: "ashfit_physical", d: "physical ashift"},
+	{n: "non_allocating", d: "not allocating new data (noalloc)"},
 }
 
+var vdevStatsByName map[string]int
+
+// vdevStatsAllowed contains the vdev stats selected by -vdev.metrics, nil if all are exported.
+var vdevStatsAllowed map[string]bool
+
+func parseVdevMetrics() error {
+	if *vdevMetrics == "" {
+		return nil
+	}
+	vdevStatsAllowed = make(map[string]bool)
+	for _, name := range strings.Split(*vdevMetrics, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := vdevStatsByName[name]; !ok {
+			return fmt.Errorf("unknown vdev stat %q", name)
+		}
+		vdevStatsAllowed[name] = true
+	}
+	return nil
+}
+
+// vdevStat returns the raw value of the named vdev stat (and variant, if the stat has variants).
+// The second return value is false if the kernel didn't report the stat.
+func vdevStat(rawStats []uint64, name, variant string) (uint64, bool) {
+	idx, ok := vdevStatsByName[name]
+	if !ok {
+		return 0, false
+	}
+	s := vdevStats[idx]
+	pos := s.offset
+	if variant != "" {
+		found := false
+		for j, v := range s.variants {
+			if v == variant {
+				pos += j
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, false
+		}
+	}
+	if pos >= len(rawStats) {
+		return 0, false
+	}
+	return rawStats[pos], true
+}
+
+// Indices into pool_checkpoint_stat_t and the checkpoint_state_t value for no checkpoint
+const (
+	checkpointStatState = 0
+	checkpointStatSpace = 2
+
+	checkpointStateNone = 0
+)
+
+// vdev_state_t values from OpenZFS
+const (
+	vdevStateClosed   = 1
+	vdevStateOffline  = 2
+	vdevStateRemoved  = 3
+	vdevStateCantOpen = 4
+	vdevStateFaulted  = 5
+	vdevStateDegraded = 6
+	vdevStateHealthy  = 7
+)
+
+// Index of the auxiliary state in vdev_stat_t and the vdev_aux_t values it is checked for
+const (
+	vdevStatAux = 2
+
+	vdevAuxCorruptData = 2
+	vdevAuxSpared      = 10
+	vdevAuxBadLog      = 13
+	vdevAuxSplitPool   = 15
+)
+
+// vdev_trim_state_t values from OpenZFS
+const (
+	trimStateSuspended = 3
+)
+
+// walkVdevs calls fn for the given vdev and all its descendants.
+func walkVdevs(vdev map[string]interface{}, fn func(vdev map[string]interface{})) {
+	fn(vdev)
+	children, _ := vdev["children"].([]map[string]interface{})
+	for _, child := range children {
+		walkVdevs(child, fn)
+	}
+}
+
+var (
+	checksumErrors   = prometheus.NewDesc("zfs_vdev_checksum_errors_total", "Number of checksum errors encountered by the vdev", vdevLabels, nil)
+	slowIOs          = prometheus.NewDesc("zfs_vdev_slow_ios_total", "Number of I/Os to the vdev which took longer than zio_slow_io_ms", vdevLabels, nil)
+	childrenPresent  = prometheus.NewDesc("zfs_vdev_children_present", "Number of child vdevs which are online or degraded", vdevLabels, nil)
+	childrenExpected = prometheus.NewDesc("zfs_vdev_children_expected", "Number of child vdevs configured", vdevLabels, nil)
+	vdevStateInfo    = prometheus.NewDesc("zfs_vdev_state_info", "State of the vdev as shown by zpool status", append(vdevLabels[:len(vdevLabels):len(vdevLabels)], "state"), nil)
+	vdevGUIDInfo     = prometheus.NewDesc("zfs_vdev_guid_info", "GUID and device path of the vdev, the GUID is stable across reboots and device renames unlike the path", append(vdevLabels[:len(vdevLabels):len(vdevLabels)], "guid", "path"), nil)
+)
+
+// Indices into pool_scan_stat_t and the relevant pool_scan_func_t and dsl_scan_state_t values
+const (
+	scanStatFunc                 = 0
+	scanStatState                = 1
+	scanStatStartTime            = 2
+	scanStatEndTime              = 3
+	scanStatToExamine            = 4
+	scanStatExamined             = 5
+	scanStatProcessed            = 7
+	scanStatErrors               = 8
+	scanStatPassStart            = 10
+	scanStatPassScrubPause       = 11
+	scanStatPassScrubSpentPaused = 12
+	scanStatPassIssued           = 13
+	scanStatIssued               = 14
+
+	scanFuncNone     = 0
+	scanFuncScrub    = 1
+	scanFuncResilver = 2
+
+	scanStateScanning = 1
+	scanStateFinished = 2
+)
+
 var (
-	extendedStatsLabels = []string{"type", "vdev", "zpool"}
+	poolScrapeSuccess     = prometheus.NewDesc("zfs_pool_scrape_success", "Whether the pool's stats could be collected", []string{"zpool"}, nil)
+	poolInfo              = prometheus.NewDesc("zfs_pool_info", "Information about the pool, the name label carries the real pool name if an alias is configured and version is the on-disk version (5000 for feature flags)", []string{"name", "guid", "hostid", "hostname", "version", "zpool"}, nil)
+	poolState             = prometheus.NewDesc("zfs_pool_state", "State of the pool (see pool_state_t, 0 is active)", []string{"zpool"}, nil)
+	poolHealth            = prometheus.NewDesc("zfs_pool_health", "Health of the pool as reported by its root vdev (see vdev_state_t, 7 is online)", []string{"zpool"}, nil)
+	poolSuspended         = prometheus.NewDesc("zfs_pool_suspended", "Whether I/O to the pool is suspended because of failing devices", []string{"zpool"}, nil)
+	checkpointExists      = prometheus.NewDesc("zfs_pool_checkpoint_exists", "Whether the pool has a checkpoint (including one which is being discarded)", []string{"zpool"}, nil)
+	checkpointSpace       = prometheus.NewDesc("zfs_pool_checkpoint_space_bytes", "Space held by the pool's checkpoint", []string{"zpool"}, nil)
+	poolErrors            = prometheus.NewDesc("zfs_pool_errors_total", "Number of errors of the pool's root vdev, as shown for the pool by zpool status", []string{"type", "zpool"}, nil)
+	poolDataErrors        = prometheus.NewDesc("zfs_pool_data_errors", "Approximate number of blocks with permanent (unrecoverable) errors, as counted by zpool status", []string{"zpool"}, nil)
+	poolCapacityRatio     = prometheus.NewDesc("zfs_pool_capacity_ratio", "Ratio of allocated to total space of the pool from the root vdev stats, which don't account for the embedded log class like zfs_pool_allocated_bytes and zfs_pool_size_bytes do", []string{"zpool"}, nil)
+	secondsSinceLastScrub = prometheus.NewDesc("zfs_pool_seconds_since_last_scrub", "Seconds since the last scrub completed (only present if the last scan was a completed scrub)", []string{"zpool"}, nil)
+	scanPaused            = prometheus.NewDesc("zfs_pool_scan_paused", "Whether the running scrub is paused", []string{"zpool"}, nil)
+	trimPaused            = prometheus.NewDesc("zfs_pool_trim_paused", "Whether TRIM is suspended on any of the pool's disks", []string{"zpool"}, nil)
+	sparesInUse           = prometheus.NewDesc("zfs_pool_spares_in_use", "Number of hot spares currently substituted for another vdev", []string{"zpool"}, nil)
+	spareInUse            = prometheus.NewDesc("zfs_pool_spare_in_use", "Whether the hot spare is currently substituted for another vdev", []string{"spare", "zpool"}, nil)
+	spareState            = prometheus.NewDesc("zfs_pool_spare_state", "State of the hot spare (see vdev_state_t)", []string{"spare", "zpool"}, nil)
+	allocationImbalance   = prometheus.NewDesc("zfs_pool_vdev_allocation_imbalance_ratio", "Standard deviation divided by mean of the used space ratio of the pool's normal class top-level vdevs", []string{"zpool"}, nil)
+	poolAshift            = prometheus.NewDesc("zfs_pool_ashift", "Largest configured ashift of the pool's normal class top-level vdevs", []string{"zpool"}, nil)
+	scanState             = prometheus.NewDesc("zfs_pool_scan_state", "State of the last or running scan (0=none, 1=scanning, 2=finished, 3=canceled)", []string{"function", "zpool"}, nil)
+	scanStartTime         = prometheus.NewDesc("zfs_pool_scan_start_timestamp_seconds", "Time the last or running scan was started", []string{"function", "zpool"}, nil)
+	scanToExamine         = prometheus.NewDesc("zfs_pool_scan_to_examine_bytes", "Total bytes to be examined by the last or running scan", []string{"function", "zpool"}, nil)
+	scanExamined          = prometheus.NewDesc("zfs_pool_scan_examined_bytes", "Bytes examined so far by the last or running scan", []string{"function", "zpool"}, nil)
+	scanProcessed         = prometheus.NewDesc("zfs_pool_scan_processed_bytes", "Bytes repaired or resilvered by the last or running scan", []string{"function", "zpool"}, nil)
+	scanErrors            = prometheus.NewDesc("zfs_pool_scan_errors", "Number of errors encountered by the last or running scan", []string{"function", "zpool"}, nil)
+	resilverETA           = prometheus.NewDesc("zfs_pool_resilver_eta_seconds", "Estimated seconds until the running resilver completes", []string{"zpool"}, nil)
+)
+
+var (
+	zfsUp             = prometheus.NewDesc("zfs_up", "Whether the ZFS pools could be enumerated and collection ran to completion", nil, nil)
+	scrapeDuration    = prometheus.NewDesc("zfs_scrape_duration_seconds", "Time it took to collect the ZFS metrics", nil, nil)
+	poolsImported     = prometheus.NewDesc("zfs_pools_imported", "Number of imported pools", nil, nil)
+	collectAllocBytes = prometheus.NewDesc("zfs_exporter_collect_alloc_bytes", "Bytes allocated by the exporter during the last collection", nil, nil)
+)
+
+var (
+	// vdev_type is the vdev's type (disk, mirror, raidz, ...), parent the name of the parent vdev
+	// and class the allocation class of the top-level vdev (normal, log, special, dedup) or cache
+	// and spare for L2ARC and hot spare devices.
+	vdevLabels          = []string{"vdev", "vdev_type", "parent", "class", "zpool"}
+	extendedStatsLabels = append([]string{"type"}, vdevLabels...)
 )
 
 var (
 	activeQueueLength  = prometheus.NewDesc("zfs_vdev_queue_active_length", "Number of ZIOs issued to disk and waiting to finish", extendedStatsLabels, nil)
 	pendingQueueLength = prometheus.NewDesc("zfs_vdev_queue_pending_length", "Number of ZIOs pending to be issued to disk", extendedStatsLabels, nil)
-	queueLatency       = prometheus.NewDesc("zfs_vdev_queue_latency", "Amount of time an IO request spent in the queue", extendedStatsLabels, nil)
-	zioLatencyTotal    = prometheus.NewDesc("zfs_vdev_zio_latency_total", "Total ZIO latency including queuing and disk access time.", extendedStatsLabels, nil)
-	zioLatencyDisk     = prometheus.NewDesc("zfs_vdev_latency_disk", "Amount of time to read/write the disk", extendedStatsLabels, nil)
-	physicalIOSize     = prometheus.NewDesc("zfs_vdev_io_size_physical", "Size of the physical I/O requests issued", extendedStatsLabels, nil)
-	aggregatedIOSize   = prometheus.NewDesc("zfs_vdev_io_size_aggregated", "Size of the aggregated I/O requests issued", extendedStatsLabels, nil)
+	queueLatency       = prometheus.NewDesc("zfs_vdev_queue_latency_seconds", "Amount of time an IO request spent in the queue", extendedStatsLabels, nil)
+	zioLatencyTotal    = prometheus.NewDesc("zfs_vdev_zio_latency_seconds", "Total ZIO latency including queuing and disk access time.", extendedStatsLabels, nil)
+	zioLatencyDisk     = prometheus.NewDesc("zfs_vdev_latency_disk_seconds", "Amount of time to read/write the disk", extendedStatsLabels, nil)
+	physicalIOSize     = prometheus.NewDesc("zfs_vdev_io_size_physical_bytes", "Size of the physical I/O requests issued", extendedStatsLabels, nil)
+	aggregatedIOSize   = prometheus.NewDesc("zfs_vdev_io_size_aggregated_bytes", "Size of the aggregated I/O requests issued", extendedStatsLabels, nil)
+)
+
+var (
+	slogCommitLatency = prometheus.NewDesc("zfs_slog_commit_latency_seconds", "Total write latency of dedicated log (SLOG) vdevs", vdevLabels, nil)
 )
 
+// isLatencyHistogram returns true if histograms of the given metric count nanoseconds. All other
+// histograms count bytes.
+func isLatencyHistogram(desc *prometheus.Desc) bool {
+	return desc == queueLatency || desc == zioLatencyTotal || desc == zioLatencyDisk || desc == slogCommitLatency
+}
+
+// newHistogram converts a power-of-two histogram from the extended vdev stats into a
+// Prometheus histogram. The kernel sorts a value v into bucket highbit(v)-1, so bucket i holds
+// the integer values from 2^i to 2^(i+1)-1. The inclusive upper bound 2^(i+1)-1 is what
+// `zpool iostat -w` labels latency buckets with.
+func newHistogram(desc *prometheus.Desc, histo []uint64, labelValues ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64)
+	var acc uint64
+	var divisor float64 = 1.0
+	if isLatencyHistogram(desc) {
+		divisor = 1_000_000_000 // 1 ns in s
+	}
+	if *nativeHistos {
+		return newNativeHistogram(desc, histo, divisor, labelValues...)
+	}
+	for i, v := range histo {
+		acc += v
+		buckets[histogramUpperBound(i)/divisor] = acc
+	}
+	return prometheus.MustNewConstHistogram(desc, acc, 0.0, buckets, labelValues...)
+}
+
+// histogramUpperBound returns the largest value counted in bucket i of a ZFS histogram.
+func histogramUpperBound(i int) float64 {
+	return math.Exp2(float64(i+1)) - 1
+}
+
 type extStat struct {
 	name  string
 	desc  *prometheus.Desc
@@ -125,23 +363,136 @@ var extStats = []extStat{
 }
 
 func init() {
+	vdevStatsByName = make(map[string]int)
+	offset := 0
 	for i, s := range vdevStats {
+		vdevStats[i].offset = offset
+		if len(s.variants) == 0 {
+			offset++
+		} else {
+			offset += len(s.variants)
+		}
 		if s.n == "" {
 			continue
 		}
-		if len(s.variants) == 0 {
-			vdevStats[i].desc = prometheus.NewDesc("zfs_vdev_"+s.n, "ZFS VDev "+s.d, []string{"vdev", "zpool"}, nil)
+		vdevStatsByName[s.n] = i
+		if s.t == 0 {
+			vdevStats[i].t = prometheus.GaugeValue
+		}
+		if len(s.variants) == 0 && len(s.enum) == 0 {
+			vdevStats[i].desc = prometheus.NewDesc("zfs_vdev_"+s.n, "ZFS VDev "+s.d, vdevLabels, nil)
 		} else {
-			vdevStats[i].desc = prometheus.NewDesc("zfs_vdev_"+s.n, "ZFS VDev "+s.d, []string{"vdev", "zpool", s.dimension}, nil)
+			vdevStats[i].desc = prometheus.NewDesc("zfs_vdev_"+s.n, "ZFS VDev "+s.d, append(vdevLabels[:len(vdevLabels):len(vdevLabels)], s.dimension), nil)
 		}
 	}
 	extStatsMap = make(map[string]extStat)
 	for _, v := range extStats {
 		extStatsMap[v.name] = v
 	}
+	registerCollector("pool", scopePool, true, subCollectorFunc((*zfsCollector).collectPoolStats))
+	registerCollector("vdev", scopePool, true, subCollectorFunc((*zfsCollector).collectVdevs))
 }
 
-type zfsCollector struct{}
+// resilverRemaining estimates the time until a running resilver completes, the same way
+// `zpool status` does. The issue rate is averaged over the whole current pass, which keeps the
+// estimate from jumping around between scrapes. If there is no running resilver or no data has
+// been issued yet, false is returned.
+func resilverRemaining(scanStats []uint64, now time.Time) (time.Duration, bool) {
+	if len(scanStats) <= scanStatIssued {
+		return 0, false
+	}
+	if scanStats[scanStatFunc] != scanFuncResilver || scanStats[scanStatState] != scanStateScanning {
+		return 0, false
+	}
+	elapsed := now.Sub(time.Unix(int64(scanStats[scanStatPassStart]), 0)) - time.Duration(scanStats[scanStatPassScrubSpentPaused])*time.Second
+	if elapsed <= 0 || scanStats[scanStatPassIssued] == 0 {
+		return 0, false
+	}
+	rate := float64(scanStats[scanStatPassIssued]) / elapsed.Seconds()
+	var remaining float64
+	if scanStats[scanStatToExamine] > scanStats[scanStatIssued] {
+		remaining = float64(scanStats[scanStatToExamine] - scanStats[scanStatIssued])
+	}
+	return time.Duration(remaining / rate * float64(time.Second)), true
+}
+
+// scanFuncName returns the name of a pool_scan_func_t value as used by `zpool status`.
+func scanFuncName(f uint64) string {
+	switch f {
+	case scanFuncScrub:
+		return "scrub"
+	case scanFuncResilver:
+		return "resilver"
+	default:
+		return fmt.Sprintf("unknown_%d", f)
+	}
+}
+
+// coefficientOfVariation returns the (population) standard deviation of vals divided by their mean.
+// It returns false if there are no values or their mean is zero.
+func coefficientOfVariation(vals []float64) (float64, bool) {
+	if len(vals) == 0 {
+		return 0, false
+	}
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	mean := sum / float64(len(vals))
+	if mean == 0 {
+		return 0, false
+	}
+	var sqDiff float64
+	for _, v := range vals {
+		sqDiff += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sqDiff/float64(len(vals))) / mean, true
+}
+
+type zfsCollector struct {
+	zfs zfsSource
+	// poolCache caches all metrics of a pool for -cache-ttl, datasetCache only the dataset and
+	// snapshot metrics for the pool's -dataset-cache-ttl.
+	poolCache    *metricCache
+	datasetCache *metricCache
+
+	// scrapeErrors counts the errors of this collector across scrapes, it is collected along with
+	// the collector's other metrics.
+	scrapeErrors *prometheus.CounterVec
+
+	mu          sync.Mutex
+	inFlight    map[string]bool      // pools which are currently being collected
+	lastSuccess map[string]time.Time // by sub-collector
+}
+
+// newZFSCollector opens the ZFS control device at devPath (/dev/zfs if empty) and returns a
+// collector for it. The ioctl package keeps a single process-wide handle to the device, so only one
+// device can be collected from per process.
+func newZFSCollector(devPath string) (*zfsCollector, error) {
+	if err := ioctl.Init(devPath); err != nil {
+		return nil, err
+	}
+	return newCollector(ioctlSource{}), nil
+}
+
+func newCollector(zfs zfsSource) *zfsCollector {
+	c := &zfsCollector{
+		zfs:          zfs,
+		poolCache:    newMetricCache(),
+		datasetCache: newMetricCache(),
+		scrapeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "zfs_scrape_errors_total",
+			Help: "Number of errors encountered while collecting, by the part of the collection which failed",
+		}, []string{"collector"}),
+		inFlight:    make(map[string]bool),
+		lastSuccess: make(map[string]time.Time),
+	}
+	// Export all error counters from the start so increase() also catches the first error
+	for _, collector := range []string{"pools", "pool", "kstat", "pool_props", "channel_program", "passthrough", "datasets", "snapshots", "bookmarks"} {
+		c.scrapeErrors.WithLabelValues(collector)
+	}
+	return c
+}
 
 func (c *zfsCollector) Describe(ch chan<- *prometheus.Desc) {
 	for _, s := range vdevStats {
@@ -150,6 +501,19 @@ func (c *zfsCollector) Describe(ch chan<- *prometheus.Desc) {
 		}
 		ch <- s.desc
 	}
+	ch <- checksumErrors
+	ch <- slowIOs
+	ch <- childrenPresent
+	ch <- childrenExpected
+	ch <- vdevStateInfo
+	ch <- vdevGUIDInfo
+	ch <- rebuildState
+	ch <- rebuildStartTime
+	ch <- rebuildToExamine
+	ch <- rebuildScanned
+	ch <- rebuildIssued
+	ch <- rebuildRebuilt
+	ch <- rebuildErrors
 	ch <- activeQueueLength
 	ch <- pendingQueueLength
 	ch <- queueLatency
@@ -157,87 +521,841 @@ func (c *zfsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- zioLatencyDisk
 	ch <- physicalIOSize
 	ch <- aggregatedIOSize
+	ch <- slogCommitLatency
+	ch <- channelProgramResult
+	ch <- zfsUp
+	ch <- scrapeDuration
+	ch <- poolsImported
+	ch <- collectAllocBytes
+	ch <- collectorDuration
+	ch <- collectorLastSuccess
+	c.scrapeErrors.Describe(ch)
+	ch <- poolScrapeSuccess
+	ch <- poolInfo
+	ch <- poolState
+	ch <- poolHealth
+	ch <- poolCapacityRatio
+	ch <- poolErrors
+	ch <- poolDataErrors
+	ch <- checkpointExists
+	ch <- checkpointSpace
+	ch <- poolSuspended
+	ch <- secondsSinceLastScrub
+	ch <- scanState
+	ch <- scanStartTime
+	ch <- scanToExamine
+	ch <- scanExamined
+	ch <- scanProcessed
+	ch <- scanErrors
+	ch <- resilverETA
+	ch <- scanPaused
+	ch <- trimPaused
+	ch <- poolAshift
+	ch <- allocationImbalance
+	ch <- sparesInUse
+	ch <- spareInUse
+	ch <- spareState
+	ch <- ddtEntries
+	ch <- ddtDiskBytes
+	ch <- ddtMemoryBytes
+	ch <- poolFeature
+	for _, m := range abdStats {
+		ch <- m.desc
+	}
+	for _, m := range metaslabStats {
+		ch <- m.desc
+	}
+	for _, m := range arcStats {
+		ch <- m.desc
+	}
+	for _, m := range zilStats {
+		ch <- m.desc
+	}
+	for _, m := range txgMetrics {
+		ch <- m.desc
+	}
+	for _, p := range poolProps {
+		ch <- p.desc
+	}
+	for _, p := range datasetProps {
+		ch <- p.desc
+	}
+	ch <- datasetCompression
+	ch <- datasetMounted
+	ch <- datasetEncrypted
+	ch <- datasetOrigin
+	ch <- datasetKeyStatus
+	for _, p := range volumeProps {
+		ch <- p.desc
+	}
+	for _, p := range filesystemProps {
+		ch <- p.desc
+	}
+	for _, m := range datasetObjsetStats {
+		ch <- m.desc
+	}
+	ch <- kstatValue
+	ch <- datasetSnapshotCount
+	ch <- datasetSnapshotUsedBytes
+	ch <- snapshotUsedBytes
+	ch <- snapshotLimitReached
+	ch <- datasetBookmarkCount
 }
 
 func (c *zfsCollector) Collect(ch chan<- prometheus.Metric) {
-	pools, err := ioctl.PoolConfigs()
+	c.collect(ch, *scrapeTimeout)
+}
+
+// timeoutCollector collects with the timeout of a single scrape instead of -scrape-timeout.
+type timeoutCollector struct {
+	*zfsCollector
+	timeout time.Duration
+}
+
+func (c timeoutCollector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(ch, c.timeout)
+}
+
+// collect collects all metrics, pools which aren't done after timeout are skipped. A zero timeout
+// waits for all pools.
+func (c *zfsCollector) collect(ch chan<- prometheus.Metric, timeout time.Duration) {
+	start := time.Now()
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	var memBefore runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+	var up float64
+	times := newScrapeTimes()
+	defer func() {
+		c.collectTimes(ch, times)
+		var memAfter runtime.MemStats
+		runtime.ReadMemStats(&memAfter)
+		ch <- prometheus.MustNewConstMetric(collectAllocBytes, prometheus.GaugeValue, float64(memAfter.TotalAlloc-memBefore.TotalAlloc))
+		ch <- prometheus.MustNewConstMetric(zfsUp, prometheus.GaugeValue, up)
+		ch <- prometheus.MustNewConstMetric(scrapeDuration, prometheus.GaugeValue, time.Since(start).Seconds())
+		c.scrapeErrors.Collect(ch)
+	}()
+	c.runCollectors(ch, scopeGlobal, nil, times)
+	pools, err := c.zfs.PoolConfigs()
 	if err != nil {
-		panic(err)
+		c.scrapeErrors.WithLabelValues("pools").Inc()
+		level.Error(logger).Log("msg", "Failed to enumerate pools", "err", err)
+		return
 	}
-	for poolName := range pools {
-		stats, err := ioctl.PoolStats(poolName)
-		if err != nil {
-			panic(err)
-		}
-		vdevTree := stats["vdev_tree"].(map[string]interface{})
-		vdevs := vdevTree["children"].([]map[string]interface{})
-		for _, vdev := range vdevs {
-			// TODO: This doesn't always seem to match what zpool shows
-			vdevName := fmt.Sprintf("%s-%d", vdev["type"], vdev["id"])
-			rawStats := vdev["vdev_stats"].([]uint64)
-			i := 0
-			for _, s := range vdevStats {
-				if i >= len(rawStats) {
-					break
-				}
-				if s.n == "" {
-					i++
-					continue
-				}
-				if len(s.variants) == 0 {
-					ch <- prometheus.MustNewConstMetric(s.desc, prometheus.UntypedValue, float64(rawStats[i]), vdevName, poolName)
-					i++
-				} else {
-					for _, v := range s.variants {
-						ch <- prometheus.MustNewConstMetric(s.desc, prometheus.UntypedValue, float64(rawStats[i]), vdevName, poolName, v)
-						i++
+	ch <- prometheus.MustNewConstMetric(poolsImported, prometheus.GaugeValue, float64(len(pools)))
+	// Cached metrics of pools which have been exported since would otherwise be kept forever
+	imported := func(poolName string) bool {
+		_, ok := pools[poolName]
+		return ok
+	}
+	c.poolCache.retain(imported)
+	c.datasetCache.retain(imported)
+	// Pools are collected concurrently, but at most -pool-concurrency at a time so a host with
+	// many pools doesn't issue a burst of ioctls. A pool which is stuck only occupies one slot.
+	sem := make(chan struct{}, *poolConcurrency)
+	// Each pool's metrics are forwarded only once the pool is done, so workers which are abandoned
+	// after the scrape timeout never send on ch. The buffer keeps them from blocking forever.
+	results := make(chan poolResult, len(pools))
+	pending := make(map[string]bool)
+	for poolName, config := range pools {
+		if !poolFilter.match(poolName) {
+			continue
+		}
+		if !c.startPool(poolName) {
+			c.scrapeErrors.WithLabelValues("pool").Inc()
+			level.Warn(logger).Log("msg", "Pool is still being collected by an earlier scrape, skipping it", "pool", poolName)
+			ch <- prometheus.MustNewConstMetric(poolScrapeSuccess, prometheus.GaugeValue, 0, poolAlias(poolName))
+			continue
+		}
+		pending[poolName] = true
+		config, _ := config.(map[string]interface{})
+		go func(poolName string, config map[string]interface{}) {
+			defer c.finishPool(poolName)
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results <- poolResult{poolName, gatherMetrics(func(ch chan<- prometheus.Metric) {
+				c.poolCache.collect(ch, poolName, *cacheTTL, func(ch chan<- prometheus.Metric) bool {
+					var success float64 = 1
+					complete, err := c.collectPool(ch, poolName, config, times)
+					if err != nil {
+						c.scrapeErrors.WithLabelValues("pool").Inc()
+						level.Warn(logger).Log("msg", "Failed to collect pool, skipping it", "pool", poolName, "err", err)
+						success = 0
 					}
-				}
+					ch <- prometheus.MustNewConstMetric(poolScrapeSuccess, prometheus.GaugeValue, success, poolAlias(poolName))
+					return complete
+				})
+			})}
+		}(poolName, config)
+	}
+	for len(pending) > 0 {
+		select {
+		case r := <-results:
+			delete(pending, r.name)
+			for _, m := range r.metrics {
+				ch <- m
 			}
-			extended_stats := vdev["vdev_stats_ex"].(map[string]interface{})
-			for name, val := range extended_stats {
-				statMeta := extStatsMap[name]
-				if statMeta.name == "" {
-					continue
-				}
-				if scalar, ok := val.(uint64); ok {
-					ch <- prometheus.MustNewConstMetric(statMeta.desc, prometheus.GaugeValue, float64(scalar), statMeta.label, vdevName, poolName)
-				} else if histo, ok := val.([]uint64); ok {
-					buckets := make(map[float64]uint64)
-					var acc uint64
-					var divisor float64 = 1.0
-					if len(histo) == 37 {
-						divisor = 1_000_000_000 // 1 ns in s
-					}
-					for i, v := range histo {
-						acc += v
-						buckets[math.Exp2(float64(i))/divisor] = acc
-					}
-					ch <- prometheus.MustNewConstHistogram(statMeta.desc, acc, 0.0, buckets, statMeta.label, vdevName, poolName)
-				} else {
-					log.Fatalf("invalid type encountered: %T", val)
-				}
+		case <-ctx.Done():
+			for poolName := range pending {
+				c.scrapeErrors.WithLabelValues("pool").Inc()
+				times.fail("pool")
+				level.Warn(logger).Log("msg", "Timed out collecting pool, skipping it", "pool", poolName)
+				ch <- prometheus.MustNewConstMetric(poolScrapeSuccess, prometheus.GaugeValue, 0, poolAlias(poolName))
 			}
+			return
+		}
+	}
+	up = 1
+}
+
+type poolResult struct {
+	name    string
+	metrics []prometheus.Metric
+}
+
+// startPool marks the pool as being collected. It returns false if it already is, which happens
+// if an ioctl on the pool blocked past the timeout of an earlier scrape.
+func (c *zfsCollector) startPool(poolName string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inFlight[poolName] {
+		return false
+	}
+	c.inFlight[poolName] = true
+	return true
+}
+
+func (c *zfsCollector) finishPool(poolName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inFlight, poolName)
+}
+
+// collectPool collects all metrics of a single pool, running each part as a named sub-collector.
+// It returns an error if the pool's stats couldn't be read, for example because the pool was
+// exported since it was enumerated. Whether all sub-collectors succeeded is returned separately,
+// the pool's metrics are only cached if they did.
+func (c *zfsCollector) collectPool(ch chan<- prometheus.Metric, poolName string, config map[string]interface{}, times *scrapeTimes) (bool, error) {
+	poolLabel := poolAlias(poolName)
+	var guid, hostid, version string
+	if v, ok := config["pool_guid"].(uint64); ok {
+		guid = strconv.FormatUint(v, 10)
+	}
+	// Formatted like hostid(1) does
+	if v, ok := config["hostid"].(uint64); ok {
+		hostid = fmt.Sprintf("%08x", v)
+	}
+	if v, ok := config["version"].(uint64); ok {
+		version = strconv.FormatUint(v, 10)
+	}
+	hostname, _ := config["hostname"].(string)
+	ch <- prometheus.MustNewConstMetric(poolInfo, prometheus.GaugeValue, 1, poolName, guid, hostid, hostname, version, poolLabel)
+	// Reading the pool's stats is accounted to the pool sub-collector, even if its metrics are
+	// disabled, as every other per-pool sub-collector depends on it.
+	pool := &poolData{name: poolName, label: poolLabel}
+	var err error
+	times.run("pool", func() error {
+		pool.stats, err = c.zfs.PoolStats(poolName)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		pool.vdevTree, ok = pool.stats["vdev_tree"].(map[string]interface{})
+		if !ok {
+			err = errors.New("pool stats contain no vdev tree")
 		}
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	complete := c.runCollectors(ch, scopePool, pool, times)
+	// The kernel only reports the failmode of suspended pools. I/O to a suspended pool blocks until
+	// it is resumed, so everything which reads from the pool is skipped for it.
+	if _, suspended := pool.stats["suspended"].(uint64); suspended {
+		return complete, nil
 	}
+	if !c.runCollectors(ch, scopePoolIO, pool, times) {
+		complete = false
+	}
+	datasetsComplete := c.datasetCache.collect(ch, poolName, datasetCacheTTL[poolName], func(ch chan<- prometheus.Metric) bool {
+		return c.runCollectors(ch, scopeDataset, pool, times)
+	})
+	return complete && datasetsComplete, nil
+}
+
+// collectPoolStats exports the pool-wide metrics contained in the pool's stats.
+func (c *zfsCollector) collectPoolStats(ch chan<- prometheus.Metric, pool *poolData) error {
+	stats, vdevTree, poolLabel := pool.stats, pool.vdevTree, pool.label
+	_, suspended := stats["suspended"].(uint64)
+	var suspendedVal float64
+	if suspended {
+		suspendedVal = 1
+	}
+	ch <- prometheus.MustNewConstMetric(poolSuspended, prometheus.GaugeValue, suspendedVal, poolLabel)
+	// The root vdev carries the aggregated stats for the whole pool
+	rootStats, _ := vdevTree["vdev_stats"].([]uint64)
+	if state, ok := stats["state"].(uint64); ok {
+		ch <- prometheus.MustNewConstMetric(poolState, prometheus.GaugeValue, float64(state), poolLabel)
+	}
+	if health, ok := vdevStat(rootStats, "state", ""); ok {
+		ch <- prometheus.MustNewConstMetric(poolHealth, prometheus.GaugeValue, float64(health), poolLabel)
+	}
+	for _, errType := range []string{"read", "write", "checksum"} {
+		if v, ok := vdevStat(rootStats, "errors", errType); ok {
+			ch <- prometheus.MustNewConstMetric(poolErrors, prometheus.CounterValue, float64(v), errType, poolLabel)
+		}
+	}
+	// The error log is only cleared once a scrub completes without finding the errors again
+	if v, ok := stats["error_count"].(uint64); ok {
+		ch <- prometheus.MustNewConstMetric(poolDataErrors, prometheus.GaugeValue, float64(v), poolLabel)
+	}
+	// Allocated space itself is exported from the pool properties, which is what zpool list shows.
+	// The root vdev's stats are available without reading from the pool though.
+	alloc, okAlloc := vdevStat(rootStats, "space_allocated_bytes", "")
+	if size, ok := vdevStat(rootStats, "space_capacity_bytes", ""); okAlloc && ok && size > 0 {
+		ch <- prometheus.MustNewConstMetric(poolCapacityRatio, prometheus.GaugeValue, float64(alloc)/float64(size), poolLabel)
+	}
+	if scanStats, ok := vdevTree["scan_stats"].([]uint64); ok && len(scanStats) > scanStatEndTime {
+		// Metrics relative to the current time are computed when they are written as these metrics
+		// can be cached by -cache-ttl.
+		if scanStats[scanStatFunc] == scanFuncScrub && scanStats[scanStatState] == scanStateFinished {
+			end := time.Unix(int64(scanStats[scanStatEndTime]), 0)
+			ch <- lazyGauge{secondsSinceLastScrub, func() float64 { return time.Since(end).Seconds() }, []string{poolLabel}}
+		}
+		if len(scanStats) > scanStatErrors && scanStats[scanStatFunc] != scanFuncNone {
+			function := scanFuncName(scanStats[scanStatFunc])
+			ch <- prometheus.MustNewConstMetric(scanState, prometheus.GaugeValue, float64(scanStats[scanStatState]), function, poolLabel)
+			ch <- prometheus.MustNewConstMetric(scanStartTime, prometheus.GaugeValue, float64(scanStats[scanStatStartTime]), function, poolLabel)
+			ch <- prometheus.MustNewConstMetric(scanToExamine, prometheus.GaugeValue, float64(scanStats[scanStatToExamine]), function, poolLabel)
+			ch <- prometheus.MustNewConstMetric(scanExamined, prometheus.GaugeValue, float64(scanStats[scanStatExamined]), function, poolLabel)
+			ch <- prometheus.MustNewConstMetric(scanProcessed, prometheus.GaugeValue, float64(scanStats[scanStatProcessed]), function, poolLabel)
+			ch <- prometheus.MustNewConstMetric(scanErrors, prometheus.GaugeValue, float64(scanStats[scanStatErrors]), function, poolLabel)
+		}
+		now := time.Now()
+		if eta, ok := resilverRemaining(scanStats, now); ok {
+			ch <- lazyGauge{resilverETA, func() float64 { return math.Max((eta - time.Since(now)).Seconds(), 0) }, []string{poolLabel}}
+		}
+		if len(scanStats) > scanStatPassScrubPause {
+			var paused float64
+			if scanStats[scanStatState] == scanStateScanning && scanStats[scanStatPassScrubPause] != 0 {
+				paused = 1
+			}
+			ch <- prometheus.MustNewConstMetric(scanPaused, prometheus.GaugeValue, paused, poolLabel)
+		}
+	}
+	// Only reported while the pool has a checkpoint
+	var hasCheckpoint float64
+	var checkpointBytes uint64
+	if cs, ok := vdevTree["checkpoint_stats"].([]uint64); ok && len(cs) > checkpointStatSpace && cs[checkpointStatState] != checkpointStateNone {
+		hasCheckpoint = 1
+		checkpointBytes = cs[checkpointStatSpace]
+	}
+	ch <- prometheus.MustNewConstMetric(checkpointExists, prometheus.GaugeValue, hasCheckpoint, poolLabel)
+	ch <- prometheus.MustNewConstMetric(checkpointSpace, prometheus.GaugeValue, float64(checkpointBytes), poolLabel)
+	// TRIM state is only tracked on leaf vdevs
+	var trimSuspended float64
+	walkVdevs(vdevTree, func(vdev map[string]interface{}) {
+		vs, _ := vdev["vdev_stats"].([]uint64)
+		if state, ok := vdevStat(vs, "trim_state", ""); ok && state == trimStateSuspended {
+			trimSuspended = 1
+		}
+	})
+	ch <- prometheus.MustNewConstMetric(trimPaused, prometheus.GaugeValue, trimSuspended, poolLabel)
+	collectDDT(ch, stats, poolLabel)
+	return nil
+}
+
+// collectVdevs exports the metrics of all vdevs of a pool, together with the pool-level metrics
+// derived from its top-level vdevs and spares.
+func (c *zfsCollector) collectVdevs(ch chan<- prometheus.Metric, pool *poolData) error {
+	vdevTree, poolLabel := pool.vdevTree, pool.label
+	vdevs, ok := vdevTree["children"].([]map[string]interface{})
+	if !ok {
+		level.Warn(logger).Log("msg", "Pool has no top-level vdevs, skipping vdev metrics", "pool", pool.name)
+	}
+	spares, _ := vdevTree["spares"].([]map[string]interface{})
+	var inUseCount int
+	for _, spare := range spares {
+		spareName := vdevName(spare)
+		vs, _ := spare["vdev_stats"].([]uint64)
+		if len(vs) <= vdevStatAux {
+			continue
+		}
+		var inUse float64
+		if vs[vdevStatAux] == vdevAuxSpared {
+			inUse = 1
+			inUseCount++
+		}
+		state, _ := vdevStat(vs, "state", "")
+		ch <- prometheus.MustNewConstMetric(spareInUse, prometheus.GaugeValue, inUse, spareName, poolLabel)
+		ch <- prometheus.MustNewConstMetric(spareState, prometheus.GaugeValue, float64(state), spareName, poolLabel)
+	}
+	ch <- prometheus.MustNewConstMetric(sparesInUse, prometheus.GaugeValue, float64(inUseCount), poolLabel)
+	var maxAshift uint64
+	var usedRatios []float64
+	for _, vdev := range vdevs {
+		// Special, dedup and log vdevs fill up independently of the data vdevs by design. Indirect
+		// vdevs are what remains of removed ones and holes are placeholders, neither allocates.
+		if vdevClass(vdev) != "normal" || vdev["type"] == "indirect" || vdev["type"] == "hole" {
+			continue
+		}
+		if ashift, ok := vdev["ashift"].(uint64); ok && ashift > maxAshift {
+			maxAshift = ashift
+		}
+		vs, _ := vdev["vdev_stats"].([]uint64)
+		alloc, okAlloc := vdevStat(vs, "space_allocated_bytes", "")
+		space, okSpace := vdevStat(vs, "space_capacity_bytes", "")
+		if okAlloc && okSpace && space > 0 {
+			usedRatios = append(usedRatios, float64(alloc)/float64(space))
+		}
+	}
+	if maxAshift != 0 {
+		ch <- prometheus.MustNewConstMetric(poolAshift, prometheus.GaugeValue, float64(maxAshift), poolLabel)
+	}
+	if imbalance, ok := coefficientOfVariation(usedRatios); ok {
+		ch <- prometheus.MustNewConstMetric(allocationImbalance, prometheus.GaugeValue, imbalance, poolLabel)
+	}
+	for _, vdev := range vdevs {
+		c.collectVdev(ch, vdev, "root", vdevClass(vdev), poolLabel)
+	}
+	l2cache, _ := vdevTree["l2cache"].([]map[string]interface{})
+	for _, vdev := range l2cache {
+		c.collectVdev(ch, vdev, "root", "cache", poolLabel)
+	}
+	for _, vdev := range spares {
+		c.collectVdev(ch, vdev, "root", "spare", poolLabel)
+	}
+	return nil
+}
+
+// vdevName returns the name `zpool status` uses for the vdev. Leaf vdevs are named by their device
+// path (or GUID if they don't have one or -vdev.label-by-guid is set), all others by type and ID
+// (like mirror-0, raidz2-1 or draid2:4d:11c:1s-0), which only depends on the pool's layout.
+// Distributed spares are always named by their path (like draid2-0-0), which isn't a device.
+func vdevName(vdev map[string]interface{}) string {
+	if _, hasChildren := vdev["children"]; !hasChildren {
+		path, hasPath := vdev["path"].(string)
+		if hasPath && (!*vdevLabelByGUID || vdev["type"] == "dspare") {
+			return path
+		}
+		if guid, ok := vdev["guid"].(uint64); ok {
+			return strconv.FormatUint(guid, 10)
+		}
+	}
+	vdevType := vdev["type"]
+	nparity, hasParity := vdev["nparity"].(uint64)
+	switch {
+	case hasParity && vdevType == "raidz":
+		vdevType = fmt.Sprintf("raidz%d", nparity)
+	case hasParity && vdevType == "draid":
+		// Like zpool_draid_name: parity, data disks per group, children and distributed spares
+		ndata, _ := vdev["draid_ndata"].(uint64)
+		nspares, _ := vdev["draid_nspares"].(uint64)
+		children, _ := vdev["children"].([]map[string]interface{})
+		vdevType = fmt.Sprintf("draid%d:%dd:%dc:%ds", nparity, ndata, len(children), nspares)
+	}
+	return fmt.Sprintf("%s-%d", vdevType, vdev["id"])
+}
+
+// vdevStateName returns the name `zpool status` shows for a vdev state and auxiliary state.
+func vdevStateName(state, aux uint64) string {
+	switch state {
+	case vdevStateClosed, vdevStateOffline:
+		return "OFFLINE"
+	case vdevStateRemoved:
+		return "REMOVED"
+	case vdevStateCantOpen:
+		switch aux {
+		case vdevAuxCorruptData, vdevAuxBadLog:
+			return "FAULTED"
+		case vdevAuxSplitPool:
+			return "SPLIT"
+		}
+		return "UNAVAIL"
+	case vdevStateFaulted:
+		return "FAULTED"
+	case vdevStateDegraded:
+		return "DEGRADED"
+	case vdevStateHealthy:
+		return "ONLINE"
+	}
+	return "UNKNOWN"
+}
+
+// vdevClass returns the allocation class of a top-level vdev.
+func vdevClass(vdev map[string]interface{}) string {
+	if bias, ok := vdev["alloc_bias"].(string); ok {
+		return bias
+	}
+	if isLog, _ := vdev["is_log"].(uint64); isLog != 0 {
+		return "log"
+	}
+	return "normal"
+}
+
+// collectVdev collects the stats of the given vdev and recursively those of all its children.
+func (c *zfsCollector) collectVdev(ch chan<- prometheus.Metric, vdev map[string]interface{}, parent, class, poolLabel string) {
+	vdevName := vdevName(vdev)
+	vdevType, _ := vdev["type"].(string)
+	labels := []string{vdevName, vdevType, parent, class, poolLabel}
+	rawStats, _ := vdev["vdev_stats"].([]uint64)
+	i := 0
+	for _, s := range vdevStats {
+		if i >= len(rawStats) {
+			break
+		}
+		if s.n == "" || (vdevStatsAllowed != nil && !vdevStatsAllowed[s.n]) {
+			if len(s.variants) == 0 {
+				i++
+			} else {
+				i += len(s.variants)
+			}
+			continue
+		}
+		if len(s.enum) != 0 {
+			state := fmt.Sprintf("unknown_%d", rawStats[i])
+			if rawStats[i] < uint64(len(s.enum)) {
+				state = s.enum[rawStats[i]]
+			}
+			ch <- prometheus.MustNewConstMetric(s.desc, s.t, 1, append(labels[:len(labels):len(labels)], state)...)
+			i++
+		} else if len(s.variants) == 0 {
+			ch <- prometheus.MustNewConstMetric(s.desc, s.t, float64(rawStats[i]), labels...)
+			i++
+		} else {
+			for _, v := range s.variants {
+				ch <- prometheus.MustNewConstMetric(s.desc, s.t, float64(rawStats[i]), append(labels[:len(labels):len(labels)], v)...)
+				i++
+			}
+		}
+	}
+	if state, ok := vdevStat(rawStats, "state", ""); ok && len(rawStats) > vdevStatAux {
+		ch <- prometheus.MustNewConstMetric(vdevStateInfo, prometheus.GaugeValue, 1, append(labels[:len(labels):len(labels)], vdevStateName(state, rawStats[vdevStatAux]))...)
+	}
+	collectRebuildStats(ch, vdev, labels)
+	if guid, ok := vdev["guid"].(uint64); ok {
+		path, _ := vdev["path"].(string)
+		ch <- prometheus.MustNewConstMetric(vdevGUIDInfo, prometheus.GaugeValue, 1, append(labels[:len(labels):len(labels)], strconv.FormatUint(guid, 10), path)...)
+	}
+	if v, ok := vdevStat(rawStats, "errors", "checksum"); ok {
+		ch <- prometheus.MustNewConstMetric(checksumErrors, prometheus.CounterValue, float64(v), labels...)
+	}
+	if v, ok := vdevStat(rawStats, "slow_ios", ""); ok {
+		ch <- prometheus.MustNewConstMetric(slowIOs, prometheus.CounterValue, float64(v), labels...)
+	}
+	if children, ok := vdev["children"].([]map[string]interface{}); ok {
+		var present int
+		for _, child := range children {
+			childStats, _ := child["vdev_stats"].([]uint64)
+			if state, ok := vdevStat(childStats, "state", ""); ok && state >= vdevStateDegraded {
+				present++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(childrenPresent, prometheus.GaugeValue, float64(present), labels...)
+		ch <- prometheus.MustNewConstMetric(childrenExpected, prometheus.GaugeValue, float64(len(children)), labels...)
+	}
+	// Cache and spare devices don't carry extended stats
+	extended_stats, _ := vdev["vdev_stats_ex"].(map[string]interface{})
+	if *noExtStats {
+		extended_stats = nil
+	}
+	for name, val := range extended_stats {
+		statMeta := extStatsMap[name]
+		if statMeta.name == "" {
+			continue
+		}
+		if scalar, ok := val.(uint64); ok {
+			ch <- prometheus.MustNewConstMetric(statMeta.desc, prometheus.GaugeValue, float64(scalar), append([]string{statMeta.label}, labels...)...)
+		} else if histo, ok := val.([]uint64); ok {
+			if *noHistos {
+				continue
+			}
+			ch <- newHistogram(statMeta.desc, histo, append([]string{statMeta.label}, labels...)...)
+			if name == "vdev_tot_w_lat_histo" && class == "log" {
+				ch <- newHistogram(slogCommitLatency, histo, labels...)
+			}
+		} else {
+			c.scrapeErrors.WithLabelValues("pool").Inc()
+			level.Warn(logger).Log("msg", "Skipping extended vdev stat of unexpected type", "stat", name, "type", fmt.Sprintf("%T", val))
+		}
+	}
+	children, _ := vdev["children"].([]map[string]interface{})
+	for _, child := range children {
+		c.collectVdev(ch, child, vdevName, class, poolLabel)
+	}
+}
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "zfs_exporter_http_requests_total",
+		Help: "Number of HTTP requests to the metrics endpoint",
+	}, []string{"code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zfs_exporter_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests to the metrics endpoint",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"code"})
+)
+
+// prefixGatherer replaces the zfs_ prefix of all metric families with a custom one. It only wraps
+// the ZFS collector's registry, metrics of the default registry aren't renamed.
+type prefixGatherer struct {
+	prometheus.Gatherer
+	prefix string
+}
+
+func (g prefixGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	for _, mf := range mfs {
+		if name := mf.GetName(); strings.HasPrefix(name, "zfs_") {
+			name = g.prefix + strings.TrimPrefix(name, "zfs_")
+			mf.Name = &name
+		}
+	}
+	return mfs, err
+}
+
+// checkMetricPrefix returns an error if prefix can't replace zfs_. Without a prefix metrics like up
+// would clash with the ones Prometheus adds to every target.
+func checkMetricPrefix(prefix string) error {
+	if !strings.HasSuffix(prefix, "_") {
+		return errors.New("prefix must end with _")
+	}
+	if !model.IsValidMetricName(model.LabelValue(prefix + "pool_health")) {
+		return errors.New("prefix must start with a letter or underscore and only contain letters, digits, underscores and colons")
+	}
+	return nil
+}
+
+// denylistGatherer drops all metric families whose name matches the denylist.
+type denylistGatherer struct {
+	prometheus.Gatherer
+	denylist *regexp.Regexp
+}
+
+func (g denylistGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	filtered := mfs[:0]
+	for _, mf := range mfs {
+		if !g.denylist.MatchString(mf.GetName()) {
+			filtered = append(filtered, mf)
+		}
+	}
+	return filtered, err
+}
+
+// landingPage links to the metrics endpoint. It is served for the route prefix with and without a
+// trailing slash, it is registered for the whole subtree below the prefix though, so all other
+// paths have to be rejected here.
+func landingPage(w http.ResponseWriter, r *http.Request) {
+	if root := routePath("/"); r.URL.Path != root && r.URL.Path != strings.TrimSuffix(root, "/")+"/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, `<html>
+<head><title>ZFS Exporter</title></head>
+<body>
+<h1>ZFS Exporter</h1>
+<p>Version: %s</p>
+<p><a href="%s">Metrics</a></p>
+</body>
+</html>
+`, html.EscapeString(version.Info()), html.EscapeString(routePath(*metricsPath)))
+}
+
+// readyHandler reports whether the ZFS control device is usable. Only the pools are enumerated,
+// which is a lot cheaper than collecting their stats.
+func (c *zfsCollector) readyHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := c.zfs.PoolConfigs(); err != nil {
+		http.Error(w, fmt.Sprintf("ZFS is not ready: %v", err), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ZFS exporter is ready.")
+}
+
+// shutdownTimeout is how long running scrapes may take to finish on shutdown.
+const shutdownTimeout = 30 * time.Second
+
+// serveUnix serves on a Unix socket at the given path. A socket left over from an earlier run is
+// replaced, the socket is removed again once the listener is closed.
+func serveUnix(server *http.Server, flags *web.FlagConfig, socketPath string) error {
+	mode, err := strconv.ParseUint(*socketMode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid socket mode: %w", err)
+	}
+	if fi, err := os.Lstat(socketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(socketPath)
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socketPath, os.FileMode(mode)); err != nil {
+		l.Close()
+		return err
+	}
+	return web.Serve(l, server, flags, logger)
+}
+
+// routePath returns the path under which the given endpoint is served, taking the route prefix
+// into account.
+func routePath(p string) string {
+	return path.Join("/", *routePrefix, p)
+}
+
+// requestTimeout returns the timeout for collecting the metrics requested by r. Prometheus sends
+// its scrape timeout along, minus -scrape-timeout-offset it is used if it is shorter than
+// -scrape-timeout.
+func requestTimeout(r *http.Request) time.Duration {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return *scrapeTimeout
+	}
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil || seconds <= 0 {
+		level.Warn(logger).Log("msg", "Ignoring invalid scrape timeout", "timeout", v)
+		return *scrapeTimeout
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	// Rather use all of a short timeout than none of it
+	if timeout > *timeoutOffset {
+		timeout -= *timeoutOffset
+	}
+	if *scrapeTimeout > 0 && *scrapeTimeout < timeout {
+		return *scrapeTimeout
+	}
+	return timeout
+}
+
+// newMux returns the handler for all HTTP endpoints under the route prefix.
+func newMux(c *zfsCollector, metricsHandler http.Handler) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle(routePath(*metricsPath), metricsHandler)
+	mux.HandleFunc(routePath("/-/ready"), c.readyHandler)
+	mux.HandleFunc(routePath("/"), landingPage)
+	// routePath strips the trailing slash, which only leaves the exact pattern for the prefix
+	// itself. The subtree pattern also routes /prefix/ to the landing page.
+	if root := routePath("/"); root != "/" {
+		mux.HandleFunc(root+"/", landingPage)
+	}
+	return mux
 }
 
 func main() {
 	flag.Parse()
+	setupLogger()
 
 	if (*versionOpt) {
 	    fmt.Println(version.Print("zfs_exporter"))
 	    return
 	}
 
-	ioctl.Init("")
+	if err := parseVdevMetrics(); err != nil {
+		level.Error(logger).Log("msg", "Invalid -vdev.metrics", "err", err)
+		os.Exit(1)
+	}
+
+	if *poolConcurrency < 1 {
+		level.Error(logger).Log("msg", "-pool-concurrency must be at least 1")
+		os.Exit(1)
+	}
 
-	c := zfsCollector{}
-	prometheus.MustRegister(&c)
+	c, err := newZFSCollector(*zfsDevPath)
+	if err != nil {
+		level.Error(logger).Log("msg", "Failed to open ZFS control device", "err", err)
+		os.Exit(1)
+	}
+	loadChannelProgram()
 	prometheus.MustRegister(version.NewCollector("zfs_exporter"))
+	prometheus.MustRegister(httpRequests, httpRequestDuration)
+
+	if err := checkMetricPrefix(*metricPrefix); err != nil {
+		level.Error(logger).Log("msg", "Invalid metric prefix", "prefix", *metricPrefix, "err", err)
+		os.Exit(1)
+	}
+	var denylistRe *regexp.Regexp
+	if *denylist != "" {
+		denylistRe, err = regexp.Compile("^(?:" + *denylist + ")$")
+		if err != nil {
+			level.Error(logger).Log("msg", "Invalid metric denylist", "err", err)
+			os.Exit(1)
+		}
+	}
+	// The ZFS collector is registered anew for every scrape so it can use the scrape's timeout,
+	// everything else is registered with the default registry.
+	zfsGatherer := func(timeout time.Duration) prometheus.Gatherer {
+		reg := prometheus.NewRegistry()
+		reg.MustRegister(timeoutCollector{c, timeout})
+		if *metricPrefix != "zfs_" {
+			return prefixGatherer{reg, *metricPrefix}
+		}
+		return reg
+	}
+	filter := func(g prometheus.Gatherer) prometheus.Gatherer {
+		if denylistRe != nil {
+			g = denylistGatherer{g, denylistRe}
+		}
+		return g
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	if err := http.ListenAndServe(*listenAddr, nil); err != nil {
-		log.Fatalf("failed to listen: %v", err)
+	if *once {
+		// Gather sorts metric families by name and metrics by their labels, so the output is
+		// stable across runs and can be diffed. The exporter's own metrics, like those of the Go
+		// runtime, would differ on every run and are left out.
+		mfs, err := filter(zfsGatherer(*scrapeTimeout)).Gather()
+		if err != nil {
+			level.Error(logger).Log("msg", "Failed to collect metrics", "err", err)
+			os.Exit(1)
+		}
+		for _, mf := range mfs {
+			if _, err := expfmt.MetricFamilyToText(os.Stdout, mf); err != nil {
+				level.Error(logger).Log("msg", "Failed to write metrics", "err", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	var metricsHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gatherer := filter(prometheus.Gatherers{zfsGatherer(requestTimeout(r)), prometheus.DefaultGatherer})
+		promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+	metricsHandler = promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, metricsHandler)
+	metricsHandler = promhttp.InstrumentHandlerCounter(httpRequests, promhttp.InstrumentHandlerDuration(httpRequestDuration, metricsHandler))
+	server := &http.Server{Handler: newMux(c, metricsHandler)}
+	systemdSocket := false
+	flags := &web.FlagConfig{
+		WebListenAddresses: &[]string{*listenAddr},
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      webConfig,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if strings.HasPrefix(*listenAddr, "unix:") {
+			serveErr <- serveUnix(server, flags, strings.TrimPrefix(*listenAddr, "unix:"))
+		} else {
+			serveErr <- web.ListenAndServe(server, flags, logger)
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		level.Error(logger).Log("msg", "Failed to listen", "err", err)
+		os.Exit(1)
+	case sig := <-signals:
+		level.Info(logger).Log("msg", "Shutting down, waiting for running scrapes to finish", "signal", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			level.Error(logger).Log("msg", "Failed to shut down cleanly", "err", err)
+			os.Exit(1)
+		}
 	}
 }
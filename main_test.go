@@ -0,0 +1,558 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// collectFunc turns a function emitting metrics into a collector for testutil.
+type collectFunc func(ch chan<- prometheus.Metric)
+
+func (f collectFunc) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(f, ch)
+}
+
+func (f collectFunc) Collect(ch chan<- prometheus.Metric) {
+	f(ch)
+}
+
+// testPool returns a source with a single pool made of one two-way mirror. The second disk has
+// the given vdev state, the mirror and the pool get the state ZFS would derive from it.
+func testPool(diskState uint64) *fakeSource {
+	poolState := uint64(vdevStateHealthy)
+	if diskState != vdevStateHealthy {
+		poolState = vdevStateDegraded
+	}
+	return &fakeSource{
+		configs: map[string]interface{}{
+			"testpool": map[string]interface{}{"pool_guid": uint64(42), "version": uint64(5000)},
+		},
+		stats: map[string]map[string]interface{}{
+			"testpool": {
+				"state": uint64(0),
+				"vdev_tree": map[string]interface{}{
+					"type":       "root",
+					"id":         uint64(0),
+					"guid":       uint64(42),
+					"vdev_stats": vdevStatsFor(map[string]uint64{"state": poolState, "space_allocated_bytes": 1 << 30, "space_capacity_bytes": 4 << 30}),
+					"children": []map[string]interface{}{{
+						"type":       "mirror",
+						"id":         uint64(0),
+						"guid":       uint64(43),
+						"ashift":     uint64(12),
+						"vdev_stats": vdevStatsFor(map[string]uint64{"state": poolState, "space_allocated_bytes": 1 << 30, "space_capacity_bytes": 4 << 30}),
+						"children": []map[string]interface{}{
+							{"type": "disk", "id": uint64(0), "guid": uint64(44), "path": "/dev/sda", "vdev_stats": vdevStatsFor(map[string]uint64{"state": vdevStateHealthy})},
+							{"type": "disk", "id": uint64(1), "guid": uint64(45), "path": "/dev/sdb", "vdev_stats": vdevStatsFor(map[string]uint64{"state": diskState})},
+						},
+					}},
+				},
+			},
+		},
+		props: map[string]map[string]interface{}{
+			"testpool": {"size": prop(uint64(4 << 30)), "allocated": prop(uint64(1 << 30)), "free": prop(uint64(3 << 30))},
+		},
+		datasets: map[string]map[string]interface{}{
+			"testpool":      {"used": prop(uint64(1 << 30))},
+			"testpool/data": {"used": prop(uint64(1 << 29))},
+		},
+		snapshots: map[string]map[string]interface{}{
+			"testpool/data@daily": {"used": prop(uint64(1 << 20))},
+		},
+	}
+}
+
+// classesPool returns a pool with a second, fuller data vdev, an almost empty special vdev with a
+// larger ashift and the indirect vdev of a removed one.
+func classesPool() *fakeSource {
+	s := testPool(vdevStateHealthy)
+	tree := s.stats["testpool"]["vdev_tree"].(map[string]interface{})
+	disk := func(id, guid uint64, path string) map[string]interface{} {
+		return map[string]interface{}{"type": "disk", "id": id, "guid": guid, "path": path, "vdev_stats": vdevStatsFor(map[string]uint64{"state": vdevStateHealthy})}
+	}
+	tree["children"] = append(tree["children"].([]map[string]interface{}),
+		map[string]interface{}{
+			"type":       "mirror",
+			"id":         uint64(1),
+			"guid":       uint64(50),
+			"ashift":     uint64(12),
+			"vdev_stats": vdevStatsFor(map[string]uint64{"state": vdevStateHealthy, "space_allocated_bytes": 3 << 30, "space_capacity_bytes": 4 << 30}),
+			"children":   []map[string]interface{}{disk(0, 51, "/dev/sdc"), disk(1, 52, "/dev/sdd")},
+		},
+		map[string]interface{}{
+			"type":       "mirror",
+			"id":         uint64(2),
+			"guid":       uint64(53),
+			"ashift":     uint64(13),
+			"alloc_bias": "special",
+			"vdev_stats": vdevStatsFor(map[string]uint64{"state": vdevStateHealthy, "space_allocated_bytes": 1 << 20, "space_capacity_bytes": 1 << 30}),
+			"children":   []map[string]interface{}{disk(0, 54, "/dev/nvme0n1"), disk(1, 55, "/dev/nvme1n1")},
+		},
+		map[string]interface{}{
+			"type":       "indirect",
+			"id":         uint64(3),
+			"guid":       uint64(56),
+			"ashift":     uint64(9),
+			"vdev_stats": vdevStatsFor(map[string]uint64{"state": vdevStateHealthy, "space_allocated_bytes": 0, "space_capacity_bytes": 0}),
+		},
+	)
+	return s
+}
+
+// volumePool returns a pool with a volume next to its filesystems.
+func volumePool() *fakeSource {
+	s := testPool(vdevStateHealthy)
+	s.datasets["testpool/vol"] = map[string]interface{}{"used": prop(uint64(1 << 30)), "volsize": prop(uint64(1 << 30))}
+	return s
+}
+
+// suspendedPool returns a degraded pool whose I/O is suspended.
+func suspendedPool() *fakeSource {
+	s := testPool(vdevStateCantOpen)
+	s.stats["testpool"]["suspended"] = uint64(0)
+	return s
+}
+
+// failingPool returns a pool which is enumerated, but whose stats can't be read.
+func failingPool() *fakeSource {
+	s := testPool(vdevStateHealthy)
+	s.statsErr = errors.New("pool went away")
+	return s
+}
+
+const (
+	poolHealthHeader = `
+# HELP zfs_pool_health Health of the pool as reported by its root vdev (see vdev_state_t, 7 is online)
+# TYPE zfs_pool_health gauge
+`
+	poolSuspendedHeader = `
+# HELP zfs_pool_suspended Whether I/O to the pool is suspended because of failing devices
+# TYPE zfs_pool_suspended gauge
+`
+	poolScrapeSuccessHeader = `
+# HELP zfs_pool_scrape_success Whether the pool's stats could be collected
+# TYPE zfs_pool_scrape_success gauge
+`
+	vdevStateInfoHeader = `
+# HELP zfs_vdev_state_info State of the vdev as shown by zpool status
+# TYPE zfs_vdev_state_info gauge
+`
+	childrenPresentHeader = `
+# HELP zfs_vdev_children_present Number of child vdevs which are online or degraded
+# TYPE zfs_vdev_children_present gauge
+`
+	poolSizeHeader = `
+# HELP zfs_pool_size_bytes Total space of the pool as shown by zpool list
+# TYPE zfs_pool_size_bytes gauge
+`
+	poolAllocatedHeader = `
+# HELP zfs_pool_allocated_bytes Allocated space of the pool as shown by zpool list, size is allocated plus free
+# TYPE zfs_pool_allocated_bytes gauge
+`
+	poolFreeHeader = `
+# HELP zfs_pool_free_bytes Free space of the pool as shown by zpool list
+# TYPE zfs_pool_free_bytes gauge
+`
+	datasetUsedHeader = `
+# HELP zfs_dataset_used_bytes Space used by the dataset and all its descendants
+# TYPE zfs_dataset_used_bytes gauge
+`
+	snapshotCountHeader = `
+# HELP zfs_dataset_snapshot_count Number of snapshots of the dataset
+# TYPE zfs_dataset_snapshot_count gauge
+`
+	snapshotLimitReachedHeader = `
+# HELP zfs_snapshot_limit_reached Whether snapshot enumeration stopped early because -snapshot.limit was reached
+# TYPE zfs_snapshot_limit_reached gauge
+`
+	imbalanceHeader = `
+# HELP zfs_pool_vdev_allocation_imbalance_ratio Standard deviation divided by mean of the used space ratio of the pool's normal class top-level vdevs
+# TYPE zfs_pool_vdev_allocation_imbalance_ratio gauge
+`
+	ashiftHeader = `
+# HELP zfs_pool_ashift Largest configured ashift of the pool's normal class top-level vdevs
+# TYPE zfs_pool_ashift gauge
+`
+	quotaHeader = `
+# HELP zfs_dataset_quota_bytes Quota of the filesystem and its descendants, 0 if there is none
+# TYPE zfs_dataset_quota_bytes gauge
+`
+	canmountHeader = `
+# HELP zfs_dataset_canmount canmount property of the filesystem (0=off, 1=on, 2=noauto)
+# TYPE zfs_dataset_canmount gauge
+`
+	readonlyHeader = `
+# HELP zfs_dataset_readonly Whether the dataset is readonly
+# TYPE zfs_dataset_readonly gauge
+`
+	scrapeErrorsHeader = `
+# HELP zfs_scrape_errors_total Number of errors encountered while collecting, by the part of the collection which failed
+# TYPE zfs_scrape_errors_total counter
+`
+)
+
+func TestCollect(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     *fakeSource
+		metrics []string
+		want    string
+	}{
+		{
+			name:    "healthy pool",
+			src:     testPool(vdevStateHealthy),
+			metrics: []string{"zfs_pool_health", "zfs_pool_suspended", "zfs_pool_scrape_success", "zfs_vdev_state_info", "zfs_pool_size_bytes", "zfs_pool_allocated_bytes", "zfs_pool_free_bytes", "zfs_dataset_used_bytes", "zfs_dataset_snapshot_count"},
+			want: poolHealthHeader + `zfs_pool_health{zpool="testpool"} 7
+` + poolSuspendedHeader + `zfs_pool_suspended{zpool="testpool"} 0
+` + poolScrapeSuccessHeader + `zfs_pool_scrape_success{zpool="testpool"} 1
+` + vdevStateInfoHeader + `zfs_vdev_state_info{class="normal",parent="mirror-0",state="ONLINE",vdev="/dev/sda",vdev_type="disk",zpool="testpool"} 1
+zfs_vdev_state_info{class="normal",parent="mirror-0",state="ONLINE",vdev="/dev/sdb",vdev_type="disk",zpool="testpool"} 1
+zfs_vdev_state_info{class="normal",parent="root",state="ONLINE",vdev="mirror-0",vdev_type="mirror",zpool="testpool"} 1
+` + poolSizeHeader + `zfs_pool_size_bytes{zpool="testpool"} 4.294967296e+09
+` + poolAllocatedHeader + `zfs_pool_allocated_bytes{zpool="testpool"} 1.073741824e+09
+` + poolFreeHeader + `zfs_pool_free_bytes{zpool="testpool"} 3.221225472e+09
+` + datasetUsedHeader + `zfs_dataset_used_bytes{name="testpool",type="filesystem",zpool="testpool"} 1.073741824e+09
+zfs_dataset_used_bytes{name="testpool/data",type="filesystem",zpool="testpool"} 5.36870912e+08
+` + snapshotCountHeader + `zfs_dataset_snapshot_count{name="testpool",zpool="testpool"} 0
+zfs_dataset_snapshot_count{name="testpool/data",zpool="testpool"} 1
+`,
+		},
+		{
+			name:    "degraded pool",
+			src:     testPool(vdevStateFaulted),
+			metrics: []string{"zfs_pool_health", "zfs_vdev_state_info", "zfs_vdev_children_present"},
+			want: poolHealthHeader + `zfs_pool_health{zpool="testpool"} 6
+` + vdevStateInfoHeader + `zfs_vdev_state_info{class="normal",parent="mirror-0",state="ONLINE",vdev="/dev/sda",vdev_type="disk",zpool="testpool"} 1
+zfs_vdev_state_info{class="normal",parent="mirror-0",state="FAULTED",vdev="/dev/sdb",vdev_type="disk",zpool="testpool"} 1
+zfs_vdev_state_info{class="normal",parent="root",state="DEGRADED",vdev="mirror-0",vdev_type="mirror",zpool="testpool"} 1
+` + childrenPresentHeader + `zfs_vdev_children_present{class="normal",parent="root",vdev="mirror-0",vdev_type="mirror",zpool="testpool"} 1
+`,
+		},
+		{
+			// Nothing which reads from the pool may be collected, that would block.
+			name:    "suspended pool",
+			src:     suspendedPool(),
+			metrics: []string{"zfs_pool_health", "zfs_pool_suspended", "zfs_pool_scrape_success", "zfs_pool_size_bytes", "zfs_pool_allocated_bytes", "zfs_dataset_used_bytes"},
+			want: poolHealthHeader + `zfs_pool_health{zpool="testpool"} 6
+` + poolSuspendedHeader + `zfs_pool_suspended{zpool="testpool"} 1
+` + poolScrapeSuccessHeader + `zfs_pool_scrape_success{zpool="testpool"} 1
+`,
+		},
+		{
+			// Only the two normal mirrors, a quarter and three quarters full, are compared.
+			name:    "special vdev",
+			src:     classesPool(),
+			metrics: []string{"zfs_pool_vdev_allocation_imbalance_ratio", "zfs_pool_ashift"},
+			want: imbalanceHeader + `zfs_pool_vdev_allocation_imbalance_ratio{zpool="testpool"} 0.5
+` + ashiftHeader + `zfs_pool_ashift{zpool="testpool"} 12
+`,
+		},
+		{
+			// Volumes have no quota or canmount property, they must not get their defaults.
+			name:    "volume",
+			src:     volumePool(),
+			metrics: []string{"zfs_dataset_quota_bytes", "zfs_dataset_canmount", "zfs_dataset_readonly"},
+			want: quotaHeader + `zfs_dataset_quota_bytes{name="testpool",type="filesystem",zpool="testpool"} 0
+zfs_dataset_quota_bytes{name="testpool/data",type="filesystem",zpool="testpool"} 0
+` + canmountHeader + `zfs_dataset_canmount{name="testpool",type="filesystem",zpool="testpool"} 1
+zfs_dataset_canmount{name="testpool/data",type="filesystem",zpool="testpool"} 1
+` + readonlyHeader + `zfs_dataset_readonly{name="testpool",type="filesystem",zpool="testpool"} 0
+zfs_dataset_readonly{name="testpool/data",type="filesystem",zpool="testpool"} 0
+zfs_dataset_readonly{name="testpool/vol",type="volume",zpool="testpool"} 0
+`,
+		},
+		{
+			name:    "failing pool stats",
+			src:     failingPool(),
+			metrics: []string{"zfs_pool_health", "zfs_pool_scrape_success", "zfs_scrape_errors_total"},
+			want: poolScrapeSuccessHeader + `zfs_pool_scrape_success{zpool="testpool"} 0
+` + scrapeErrorsHeader + `zfs_scrape_errors_total{collector="bookmarks"} 0
+zfs_scrape_errors_total{collector="channel_program"} 0
+zfs_scrape_errors_total{collector="datasets"} 0
+zfs_scrape_errors_total{collector="kstat"} 0
+zfs_scrape_errors_total{collector="passthrough"} 0
+zfs_scrape_errors_total{collector="pool"} 1
+zfs_scrape_errors_total{collector="pool_props"} 0
+zfs_scrape_errors_total{collector="pools"} 0
+zfs_scrape_errors_total{collector="snapshots"} 0
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCollector(tt.src)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), tt.metrics...); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestResilverRemaining(t *testing.T) {
+	now := time.Unix(10000, 0)
+	// A resilver which started 100s ago and issued 1000 of 5000 bytes so far
+	resilver := func() []uint64 {
+		s := make([]uint64, scanStatIssued+1)
+		s[scanStatFunc] = scanFuncResilver
+		s[scanStatState] = scanStateScanning
+		s[scanStatPassStart] = 9900
+		s[scanStatPassIssued] = 1000
+		s[scanStatToExamine] = 5000
+		s[scanStatIssued] = 1000
+		return s
+	}
+	tests := []struct {
+		name   string
+		modify func(s []uint64) []uint64
+		want   time.Duration
+		ok     bool
+	}{
+		{"running", func(s []uint64) []uint64 { return s }, 400 * time.Second, true},
+		{"paused for half of the pass", func(s []uint64) []uint64 { s[scanStatPassScrubSpentPaused] = 50; return s }, 200 * time.Second, true},
+		{"nothing issued yet", func(s []uint64) []uint64 { s[scanStatPassIssued] = 0; return s }, 0, false},
+		{"scrub", func(s []uint64) []uint64 { s[scanStatFunc] = scanFuncScrub; return s }, 0, false},
+		{"finished", func(s []uint64) []uint64 { s[scanStatState] = scanStateFinished; return s }, 0, false},
+		{"truncated stats", func(s []uint64) []uint64 { return s[:scanStatIssued] }, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := resilverRemaining(tt.modify(resilver()), now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPrefixGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(newCollector(testPool(vdevStateHealthy)))
+	mfs, err := prefixGatherer{reg, "node_zfs_"}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, mf := range mfs {
+		if !strings.HasPrefix(mf.GetName(), "node_zfs_") {
+			t.Errorf("metric %s wasn't renamed", mf.GetName())
+		}
+		names[mf.GetName()] = true
+	}
+	for _, name := range []string{"node_zfs_up", "node_zfs_pool_health", "node_zfs_exporter_collect_alloc_bytes"} {
+		if !names[name] {
+			t.Errorf("metric %s is missing", name)
+		}
+	}
+}
+
+func TestVdevName(t *testing.T) {
+	disks := func(n int) []map[string]interface{} {
+		children := make([]map[string]interface{}, n)
+		for i := range children {
+			children[i] = map[string]interface{}{"type": "disk", "id": uint64(i), "path": fmt.Sprintf("/dev/sd%c", 'a'+i)}
+		}
+		return children
+	}
+	tests := []struct {
+		name   string
+		vdev   map[string]interface{}
+		byGUID bool
+		want   string
+	}{
+		{"disk", map[string]interface{}{"type": "disk", "id": uint64(0), "guid": uint64(44), "path": "/dev/sda"}, false, "/dev/sda"},
+		{"disk by GUID", map[string]interface{}{"type": "disk", "id": uint64(0), "guid": uint64(44), "path": "/dev/sda"}, true, "44"},
+		{"disk without path", map[string]interface{}{"type": "disk", "id": uint64(0), "guid": uint64(44)}, false, "44"},
+		{"mirror", map[string]interface{}{"type": "mirror", "id": uint64(1), "children": disks(2)}, false, "mirror-1"},
+		{"raidz", map[string]interface{}{"type": "raidz", "id": uint64(0), "nparity": uint64(2), "children": disks(6)}, false, "raidz2-0"},
+		{"draid", map[string]interface{}{"type": "draid", "id": uint64(0), "nparity": uint64(2), "draid_ndata": uint64(4), "draid_nspares": uint64(1), "children": disks(11)}, false, "draid2:4d:11c:1s-0"},
+		{"distributed spare", map[string]interface{}{"type": "dspare", "id": uint64(0), "guid": uint64(46), "path": "draid2-0-0"}, false, "draid2-0-0"},
+		{"distributed spare by GUID", map[string]interface{}{"type": "dspare", "id": uint64(0), "guid": uint64(46), "path": "draid2-0-0"}, true, "draid2-0-0"},
+	}
+	defer func(byGUID bool) { *vdevLabelByGUID = byGUID }(*vdevLabelByGUID)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*vdevLabelByGUID = tt.byGUID
+			if got := vdevName(tt.vdev); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVdevStateName(t *testing.T) {
+	tests := []struct {
+		state, aux uint64
+		want       string
+	}{
+		{vdevStateHealthy, 0, "ONLINE"},
+		{vdevStateDegraded, 0, "DEGRADED"},
+		{vdevStateFaulted, 0, "FAULTED"},
+		{vdevStateCantOpen, 0, "UNAVAIL"},
+		{vdevStateCantOpen, vdevAuxCorruptData, "FAULTED"},
+		{vdevStateCantOpen, vdevAuxBadLog, "FAULTED"},
+		{vdevStateCantOpen, vdevAuxSplitPool, "SPLIT"},
+		{vdevStateRemoved, 0, "REMOVED"},
+		{vdevStateOffline, 0, "OFFLINE"},
+		{vdevStateClosed, 0, "OFFLINE"},
+		{0, 0, "UNKNOWN"},
+	}
+	for _, tt := range tests {
+		if got := vdevStateName(tt.state, tt.aux); got != tt.want {
+			t.Errorf("vdevStateName(%d, %d) = %q, want %q", tt.state, tt.aux, got, tt.want)
+		}
+	}
+}
+
+func TestCoefficientOfVariation(t *testing.T) {
+	tests := []struct {
+		name   string
+		vals   []float64
+		want   float64
+		wantOK bool
+	}{
+		{"no vdevs", nil, 0, false},
+		{"empty vdevs", []float64{0, 0}, 0, false},
+		{"single vdev", []float64{0.5}, 0, true},
+		{"balanced", []float64{0.4, 0.4, 0.4}, 0, true},
+		{"imbalanced", []float64{0.2, 0.6}, 0.5, true},
+		{"one new vdev", []float64{0.8, 0.8, 0.8, 0}, 1 / math.Sqrt(3), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := coefficientOfVariation(tt.vals)
+			if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHistogramUpperBound(t *testing.T) {
+	// Bucket i holds the values from 2^i to 2^(i+1)-1, bucket 0 only holds 1.
+	tests := []struct {
+		i    int
+		want float64
+	}{
+		{0, 1},
+		{1, 3},
+		{2, 7},
+		{9, 1023},
+		{36, 137438953471},
+	}
+	for _, tt := range tests {
+		if got := histogramUpperBound(tt.i); got != tt.want {
+			t.Errorf("histogramUpperBound(%d) = %v, want %v", tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestNewHistogram(t *testing.T) {
+	sizes := prometheus.NewDesc("zfs_test_size_bytes", "Test histogram of sizes", nil, nil)
+	labels := []string{"/dev/sda", "disk", "root", "log", "testpool"}
+	tests := []struct {
+		name   string
+		desc   *prometheus.Desc
+		histo  []uint64
+		labels []string
+		want   string
+	}{
+		{
+			name:  "sizes",
+			desc:  sizes,
+			histo: []uint64{0, 0, 0, 2, 1},
+			want: `
+# HELP zfs_test_size_bytes Test histogram of sizes
+# TYPE zfs_test_size_bytes histogram
+zfs_test_size_bytes_bucket{le="1"} 0
+zfs_test_size_bytes_bucket{le="3"} 0
+zfs_test_size_bytes_bucket{le="7"} 0
+zfs_test_size_bytes_bucket{le="15"} 2
+zfs_test_size_bytes_bucket{le="31"} 3
+zfs_test_size_bytes_bucket{le="+Inf"} 3
+zfs_test_size_bytes_sum 0
+zfs_test_size_bytes_count 3
+`,
+		},
+		{
+			// Latencies are counted in nanoseconds
+			name:   "latencies",
+			desc:   slogCommitLatency,
+			histo:  []uint64{1, 0, 0},
+			labels: labels,
+			want: `
+# HELP zfs_slog_commit_latency_seconds Total write latency of dedicated log (SLOG) vdevs
+# TYPE zfs_slog_commit_latency_seconds histogram
+zfs_slog_commit_latency_seconds_bucket{class="log",parent="root",vdev="/dev/sda",vdev_type="disk",zpool="testpool",le="1e-09"} 1
+zfs_slog_commit_latency_seconds_bucket{class="log",parent="root",vdev="/dev/sda",vdev_type="disk",zpool="testpool",le="3e-09"} 1
+zfs_slog_commit_latency_seconds_bucket{class="log",parent="root",vdev="/dev/sda",vdev_type="disk",zpool="testpool",le="7e-09"} 1
+zfs_slog_commit_latency_seconds_bucket{class="log",parent="root",vdev="/dev/sda",vdev_type="disk",zpool="testpool",le="+Inf"} 1
+zfs_slog_commit_latency_seconds_sum{class="log",parent="root",vdev="/dev/sda",vdev_type="disk",zpool="testpool"} 0
+zfs_slog_commit_latency_seconds_count{class="log",parent="root",vdev="/dev/sda",vdev_type="disk",zpool="testpool"} 1
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := collectFunc(func(ch chan<- prometheus.Metric) { ch <- newHistogram(tt.desc, tt.histo, tt.labels...) })
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestParseVdevMetrics(t *testing.T) {
+	tests := []struct {
+		flag    string
+		want    map[string]bool
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"errors", map[string]bool{"errors": true}, false},
+		{"space_allocated_bytes, errors", map[string]bool{"space_allocated_bytes": true, "errors": true}, false},
+		{"errors,bogus", nil, true},
+	}
+	defer func(flag string, allowed map[string]bool) {
+		*vdevMetrics, vdevStatsAllowed = flag, allowed
+	}(*vdevMetrics, vdevStatsAllowed)
+	for _, tt := range tests {
+		t.Run(tt.flag, func(t *testing.T) {
+			*vdevMetrics, vdevStatsAllowed = tt.flag, nil
+			err := parseVdevMetrics()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(vdevStatsAllowed, tt.want) {
+				t.Errorf("got %v, want %v", vdevStatsAllowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckMetricPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		valid  bool
+	}{
+		{"zfs_", true},
+		{"node_zfs_", true},
+		{"_", true},
+		{"", false},
+		{"zfs", false},
+		{"0zfs_", false},
+		{"zfs-exporter_", false},
+	}
+	for _, tt := range tests {
+		if err := checkMetricPrefix(tt.prefix); (err == nil) != tt.valid {
+			t.Errorf("checkMetricPrefix(%q) = %v, want valid: %v", tt.prefix, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The mounted property is not tracked by the kernel, libzfs determines it from the mount table too.
+// The exporter's own mount table differs from the host's if it runs in a container or with a
+// private mount namespace, so the one of init is read. That is the host's as long as the exporter
+// shares the host's PID namespace. The exporter's own is used if init's can't be read.
+var mountTables = []string{"/proc/1/mounts", "/proc/self/mounts"}
+
+var datasetMounted = prometheus.NewDesc("zfs_dataset_mounted", "Whether the filesystem is mounted in the mount namespace of PID 1, which is the host's unless the exporter runs in its own PID namespace", []string{"name", "mountpoint", "zpool"}, nil)
+
+// readMountedDatasets returns the names of all mounted ZFS filesystems.
+func readMountedDatasets() (map[string]bool, error) {
+	var f *os.File
+	var err error
+	for _, table := range mountTables {
+		if f, err = os.Open(table); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountTable(f)
+}
+
+// parseMountTable returns the names of all ZFS filesystems in a mount table in fstab format.
+func parseMountTable(r io.Reader) (map[string]bool, error) {
+	mounted := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[2] != "zfs" {
+			continue
+		}
+		mounted[unescapeMountField(fields[0])] = true
+	}
+	return mounted, scanner.Err()
+}
+
+// unescapeMountField undoes the octal escaping of whitespace and backslashes in the mount table.
+func unescapeMountField(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(s)
+}
+
+// datasetMountpoint returns the effective mountpoint of a filesystem like `zfs get mountpoint`.
+// An inherited mountpoint is reported by the kernel as the value set on the ancestor, the path of
+// the dataset below that ancestor needs to be appended to it. The pool's altroot, if it has one,
+// is prepended to all mountpoints.
+func datasetMountpoint(name string, props map[string]interface{}, altroot string) string {
+	prop, ok := props["mountpoint"].(map[string]interface{})
+	if !ok {
+		return path.Join("/", altroot, name)
+	}
+	value, _ := prop["value"].(string)
+	source, _ := prop["source"].(string)
+	if value == "none" || value == "legacy" {
+		return value
+	}
+	if strings.HasPrefix(name, source+"/") {
+		value = path.Join(value, strings.TrimPrefix(name, source+"/"))
+	}
+	if altroot != "" && strings.HasPrefix(value, "/") {
+		return path.Join(altroot, value)
+	}
+	return value
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMountTable(t *testing.T) {
+	table := `rpool/ROOT/debian / zfs rw,relatime,xattr,posixacl 0 0
+proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0
+tank/my\040files /tank/my\040files zfs rw,xattr,noacl 0 0
+/dev/sda1 /boot ext4 rw,relatime 0 0
+`
+	got, err := parseMountTable(strings.NewReader(table))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"rpool/ROOT/debian": true, "tank/my files": true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestDatasetMountpoint(t *testing.T) {
+	mountpoint := func(value, source string) map[string]interface{} {
+		return map[string]interface{}{"mountpoint": map[string]interface{}{"value": value, "source": source}}
+	}
+	tests := []struct {
+		name    string
+		dataset string
+		props   map[string]interface{}
+		altroot string
+		want    string
+	}{
+		{"default", "tank/data", map[string]interface{}{}, "", "/tank/data"},
+		{"set locally", "tank/data", mountpoint("/srv", "tank/data"), "", "/srv"},
+		{"inherited", "tank/data/db", mountpoint("/srv", "tank/data"), "", "/srv/db"},
+		{"inherited from root", "tank/data", mountpoint("/", "tank"), "", "/data"},
+		{"root", "tank", mountpoint("/", "tank"), "", "/"},
+		{"none", "tank/data", mountpoint("none", "tank"), "", "none"},
+		{"legacy", "tank/data", mountpoint("legacy", "tank/data"), "", "legacy"},
+		{"default with altroot", "tank/data", map[string]interface{}{}, "/mnt", "/mnt/tank/data"},
+		{"inherited with altroot", "tank/data/db", mountpoint("/srv", "tank/data"), "/mnt", "/mnt/srv/db"},
+		{"root with altroot", "tank", mountpoint("/", "tank"), "/mnt", "/mnt"},
+		{"legacy with altroot", "tank/data", mountpoint("legacy", "tank/data"), "/mnt", "legacy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := datasetMountpoint(tt.dataset, tt.props, tt.altroot); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var nativeHistos = flag.Bool("histogram.native", false, "Export the extended vdev stats histograms as native histograms instead of classic ones. Native histograms are only transferred if Prometheus scrapes using the protobuf format.")
+
+// nativeHistogram is a constant native histogram with schema 0, i.e. power-of-two buckets, which
+// matches the ZFS histograms. client_golang doesn't offer constant native histograms yet.
+type nativeHistogram struct {
+	desc       *prometheus.Desc
+	labelPairs []*dto.LabelPair
+	count      uint64
+	spans      []*dto.BucketSpan
+	deltas     []int64
+}
+
+// newNativeHistogram converts a power-of-two histogram from the extended vdev stats into a
+// native histogram. Each bucket is put into the native bucket containing its upper bound, so
+// values are at most overestimated by a factor of two.
+func newNativeHistogram(desc *prometheus.Desc, histo []uint64, divisor float64, labelValues ...string) prometheus.Metric {
+	h := &nativeHistogram{desc: desc, labelPairs: prometheus.MakeLabelPairs(desc, labelValues)}
+	var prevIdx int32
+	var prevCount int64
+	for i, v := range histo {
+		h.count += v
+		if v == 0 {
+			continue
+		}
+		// Schema 0 bucket idx covers (2^(idx-1), 2^idx]
+		idx := int32(math.Ceil(math.Log2(histogramUpperBound(i) / divisor)))
+		if len(h.spans) == 0 || idx != prevIdx+1 {
+			offset := idx
+			if len(h.spans) != 0 {
+				offset = idx - prevIdx - 1
+			}
+			h.spans = append(h.spans, &dto.BucketSpan{Offset: &offset, Length: new(uint32)})
+		}
+		*h.spans[len(h.spans)-1].Length++
+		h.deltas = append(h.deltas, int64(v)-prevCount)
+		prevIdx, prevCount = idx, int64(v)
+	}
+	return h
+}
+
+func (h *nativeHistogram) Desc() *prometheus.Desc {
+	return h.desc
+}
+
+func (h *nativeHistogram) Write(m *dto.Metric) error {
+	var schema int32
+	var zeroThreshold, sum float64
+	var zeroCount uint64
+	m.Label = h.labelPairs
+	m.Histogram = &dto.Histogram{
+		SampleCount:   &h.count,
+		SampleSum:     &sum,
+		Schema:        &schema,
+		ZeroThreshold: &zeroThreshold,
+		ZeroCount:     &zeroCount,
+		PositiveSpan:  h.spans,
+		PositiveDelta: h.deltas,
+	}
+	return nil
+}
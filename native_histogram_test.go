@@ -0,0 +1,81 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestNewNativeHistogram(t *testing.T) {
+	desc := prometheus.NewDesc("zfs_test_size_bytes", "Test histogram of sizes", nil, nil)
+	type span struct {
+		offset int32
+		length uint32
+	}
+	tests := []struct {
+		name    string
+		histo   []uint64
+		divisor float64
+		count   uint64
+		spans   []span
+		deltas  []int64
+	}{
+		{
+			name:  "empty",
+			histo: []uint64{0, 0, 0},
+		},
+		{
+			// Bucket i is put into the native bucket containing 2^(i+1)-1, which is bucket i+1
+			// except for bucket 0, which only holds 1.
+			name:   "contiguous",
+			histo:  []uint64{1, 2, 3},
+			count:  6,
+			spans:  []span{{0, 1}, {1, 2}},
+			deltas: []int64{1, 1, 1},
+		},
+		{
+			name:   "gap",
+			histo:  []uint64{0, 3, 5, 0, 2},
+			count:  10,
+			spans:  []span{{2, 2}, {1, 1}},
+			deltas: []int64{3, 2, -3},
+		},
+		{
+			// 2^30-1ns is just above one second, which is native bucket 1
+			name:    "nanoseconds",
+			histo:   append(make([]uint64, 29), 4),
+			divisor: 1e9,
+			count:   4,
+			spans:   []span{{1, 1}},
+			deltas:  []int64{4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			divisor := tt.divisor
+			if divisor == 0 {
+				divisor = 1
+			}
+			var m dto.Metric
+			if err := newNativeHistogram(desc, tt.histo, divisor).Write(&m); err != nil {
+				t.Fatal(err)
+			}
+			h := m.GetHistogram()
+			if h.GetSampleCount() != tt.count {
+				t.Errorf("got count %d, want %d", h.GetSampleCount(), tt.count)
+			}
+			var spans []span
+			for _, s := range h.GetPositiveSpan() {
+				spans = append(spans, span{s.GetOffset(), s.GetLength()})
+			}
+			if !reflect.DeepEqual(spans, tt.spans) {
+				t.Errorf("got spans %v, want %v", spans, tt.spans)
+			}
+			if !reflect.DeepEqual(h.GetPositiveDelta(), tt.deltas) {
+				t.Errorf("got deltas %v, want %v", h.GetPositiveDelta(), tt.deltas)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("passthrough", scopePool, false, subCollectorFunc((*zfsCollector).collectPassthroughKstats))
+}
+
+var (
+	kstatValue = prometheus.NewDesc("zfs_kstat", "Raw value from a per-pool ZFS kstat", []string{"file", "name", "zpool"}, nil)
+)
+
+// collectPassthroughKstats exports every numeric value of every named kstat of the given pool
+// verbatim. This gives access to kstats the exporter doesn't know about (yet), but exports a lot
+// of series, so it is disabled by default. Unreadable kstats are skipped, the last error is
+// returned.
+func (c *zfsCollector) collectPassthroughKstats(ch chan<- prometheus.Metric, pool *poolData) error {
+	poolName := pool.name
+	files, err := os.ReadDir(filepath.Join(kstatRoot, poolName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		c.scrapeErrors.WithLabelValues("passthrough").Inc()
+		level.Warn(logger).Log("msg", "Failed to list kstats", "pool", poolName, "err", err)
+		return err
+	}
+	var lastErr error
+	poolLabel := pool.label
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		stats, err := readKstat(filepath.Join(poolName, f.Name()))
+		if err == errNotNamedKstat {
+			continue
+		} else if err != nil {
+			c.scrapeErrors.WithLabelValues("passthrough").Inc()
+			level.Warn(logger).Log("msg", "Failed to read kstat", "kstat", f.Name(), "pool", poolName, "err", err)
+			lastErr = err
+			continue
+		}
+		for name, v := range stats.values {
+			ch <- prometheus.MustNewConstMetric(kstatValue, prometheus.GaugeValue, v, f.Name(), name, poolLabel)
+		}
+	}
+	return lastErr
+}
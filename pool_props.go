@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("pool_props", scopePoolIO, true, subCollectorFunc((*zfsCollector).collectPoolProps))
+}
+
+// propMetric maps a numeric ZFS property to a metric. Ratio properties are stored as integer
+// percentages by ZFS, those set divisor to 100 to export a proper ratio.
+type propMetric struct {
+	name    string
+	desc    *prometheus.Desc
+	divisor float64
+}
+
+var poolProps = []propMetric{
+	{"size", prometheus.NewDesc("zfs_pool_size_bytes", "Total space of the pool as shown by zpool list", []string{"zpool"}, nil), 1},
+	{"allocated", prometheus.NewDesc("zfs_pool_allocated_bytes", "Allocated space of the pool as shown by zpool list, size is allocated plus free", []string{"zpool"}, nil), 1},
+	{"free", prometheus.NewDesc("zfs_pool_free_bytes", "Free space of the pool as shown by zpool list", []string{"zpool"}, nil), 1},
+	{"autotrim", prometheus.NewDesc("zfs_pool_autotrim_enabled", "Whether automatic TRIM is enabled on the pool", []string{"zpool"}, nil), 1},
+	{"fragmentation", prometheus.NewDesc("zfs_pool_fragmentation_ratio", "Average fragmentation of the free space of the pool's normal class vdevs", []string{"zpool"}, nil), 100},
+	{"dedupratio", prometheus.NewDesc("zfs_pool_dedup_ratio", "Ratio of referenced to allocated space of deduplicated blocks", []string{"zpool"}, nil), 100},
+	{"bcloneused", prometheus.NewDesc("zfs_pool_brt_used_bytes", "Space used by cloned blocks (block reference table)", []string{"zpool"}, nil), 1},
+	{"bclonesaved", prometheus.NewDesc("zfs_pool_brt_saved_bytes", "Space saved by block cloning", []string{"zpool"}, nil), 1},
+	{"bcloneratio", prometheus.NewDesc("zfs_pool_brt_ratio", "Ratio of referenced to used space of cloned blocks", []string{"zpool"}, nil), 100},
+}
+
+// Properties which are only stored once they are set, with their built-in defaults
+var poolPropDefaults = map[string]uint64{
+	"autotrim": 0,
+}
+
+func (c *zfsCollector) collectPoolProps(ch chan<- prometheus.Metric, pool *poolData) error {
+	poolName := pool.name
+	props, err := c.zfs.PoolGetProps(poolName)
+	if err != nil {
+		c.scrapeErrors.WithLabelValues("pool_props").Inc()
+		level.Warn(logger).Log("msg", "Failed to get pool properties", "pool", poolName, "err", err)
+		return err
+	}
+	poolLabel := pool.label
+	for _, p := range poolProps {
+		v, ok := propUint64(props, p.name)
+		if !ok {
+			v, ok = poolPropDefaults[p.name]
+		}
+		// Values which can't be determined (like fragmentation without spacemap_histogram) are
+		// reported as UINT64_MAX.
+		if ok && v != math.MaxUint64 {
+			ch <- prometheus.MustNewConstMetric(p.desc, prometheus.GaugeValue, float64(v)/p.divisor, poolLabel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ZPOOL_CONFIG_REBUILD_STATS, unlike most vdev config keys it is namespaced
+const rebuildStatsKey = "org.openzfs:rebuild_stats"
+
+// Indices into vdev_rebuild_stat_t
+const (
+	rebuildStatState        = 0
+	rebuildStatStartTime    = 1
+	rebuildStatBytesScanned = 4
+	rebuildStatBytesIssued  = 5
+	rebuildStatBytesRebuilt = 6
+	rebuildStatBytesEst     = 7
+	rebuildStatErrors       = 8
+)
+
+// vdev_rebuild_state_t values, indexed by value
+var rebuildStateNames = []string{"none", "active", "canceled", "complete"}
+
+var (
+	rebuildState     = prometheus.NewDesc("zfs_vdev_rebuild_state", "State of the last or running sequential rebuild of the top-level vdev", append(vdevLabels[:len(vdevLabels):len(vdevLabels)], "state"), nil)
+	rebuildStartTime = prometheus.NewDesc("zfs_vdev_rebuild_start_timestamp_seconds", "Time the last or running sequential rebuild was started", vdevLabels, nil)
+	rebuildToExamine = prometheus.NewDesc("zfs_vdev_rebuild_to_examine_bytes", "Estimated bytes the sequential rebuild has to scan", vdevLabels, nil)
+	rebuildScanned   = prometheus.NewDesc("zfs_vdev_rebuild_scanned_bytes", "Bytes scanned by the sequential rebuild so far", vdevLabels, nil)
+	rebuildIssued    = prometheus.NewDesc("zfs_vdev_rebuild_issued_bytes", "Bytes issued for rebuilding by the sequential rebuild so far", vdevLabels, nil)
+	rebuildRebuilt   = prometheus.NewDesc("zfs_vdev_rebuild_rebuilt_bytes", "Bytes rebuilt by the sequential rebuild so far", vdevLabels, nil)
+	rebuildErrors    = prometheus.NewDesc("zfs_vdev_rebuild_errors", "Number of errors encountered by the sequential rebuild", vdevLabels, nil)
+)
+
+// collectRebuildStats exports the sequential rebuild (zpool attach/replace -s, dRAID) progress.
+// Only top-level vdevs which have been rebuilt since the pool was imported carry rebuild stats,
+// healing resilvers are reported in the pool's scan stats instead.
+func collectRebuildStats(ch chan<- prometheus.Metric, vdev map[string]interface{}, labels []string) {
+	rs, ok := vdev[rebuildStatsKey].([]uint64)
+	if !ok || len(rs) <= rebuildStatErrors {
+		return
+	}
+	state := fmt.Sprintf("unknown_%d", rs[rebuildStatState])
+	if rs[rebuildStatState] < uint64(len(rebuildStateNames)) {
+		state = rebuildStateNames[rs[rebuildStatState]]
+	}
+	ch <- prometheus.MustNewConstMetric(rebuildState, prometheus.GaugeValue, 1, append(labels[:len(labels):len(labels)], state)...)
+	ch <- prometheus.MustNewConstMetric(rebuildStartTime, prometheus.GaugeValue, float64(rs[rebuildStatStartTime]), labels...)
+	ch <- prometheus.MustNewConstMetric(rebuildToExamine, prometheus.GaugeValue, float64(rs[rebuildStatBytesEst]), labels...)
+	ch <- prometheus.MustNewConstMetric(rebuildScanned, prometheus.GaugeValue, float64(rs[rebuildStatBytesScanned]), labels...)
+	ch <- prometheus.MustNewConstMetric(rebuildIssued, prometheus.GaugeValue, float64(rs[rebuildStatBytesIssued]), labels...)
+	ch <- prometheus.MustNewConstMetric(rebuildRebuilt, prometheus.GaugeValue, float64(rs[rebuildStatBytesRebuilt]), labels...)
+	ch <- prometheus.MustNewConstMetric(rebuildErrors, prometheus.GaugeValue, float64(rs[rebuildStatErrors]), labels...)
+}
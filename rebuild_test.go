@@ -0,0 +1,36 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectRebuildStats(t *testing.T) {
+	vdev := map[string]interface{}{
+		"type": "mirror",
+		// state, start time, end time, merged time, scanned, issued, rebuilt, estimated, errors
+		rebuildStatsKey: []uint64{1, 1700000000, 0, 0, 300, 200, 100, 1000, 2},
+	}
+	labels := []string{"mirror-0", "mirror", "root", "normal", "testpool"}
+	want := `
+# HELP zfs_vdev_rebuild_state State of the last or running sequential rebuild of the top-level vdev
+# TYPE zfs_vdev_rebuild_state gauge
+zfs_vdev_rebuild_state{class="normal",parent="root",state="active",vdev="mirror-0",vdev_type="mirror",zpool="testpool"} 1
+# HELP zfs_vdev_rebuild_scanned_bytes Bytes scanned by the sequential rebuild so far
+# TYPE zfs_vdev_rebuild_scanned_bytes gauge
+zfs_vdev_rebuild_scanned_bytes{class="normal",parent="root",vdev="mirror-0",vdev_type="mirror",zpool="testpool"} 300
+# HELP zfs_vdev_rebuild_to_examine_bytes Estimated bytes the sequential rebuild has to scan
+# TYPE zfs_vdev_rebuild_to_examine_bytes gauge
+zfs_vdev_rebuild_to_examine_bytes{class="normal",parent="root",vdev="mirror-0",vdev_type="mirror",zpool="testpool"} 1000
+# HELP zfs_vdev_rebuild_errors Number of errors encountered by the sequential rebuild
+# TYPE zfs_vdev_rebuild_errors gauge
+zfs_vdev_rebuild_errors{class="normal",parent="root",vdev="mirror-0",vdev_type="mirror",zpool="testpool"} 2
+`
+	c := collectFunc(func(ch chan<- prometheus.Metric) { collectRebuildStats(ch, vdev, labels) })
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "zfs_vdev_rebuild_state", "zfs_vdev_rebuild_scanned_bytes", "zfs_vdev_rebuild_to_examine_bytes", "zfs_vdev_rebuild_errors"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"sort"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("snapshot", scopeDataset, true, subCollectorFunc((*zfsCollector).collectSnapshots))
+}
+
+var (
+	snapshotTopN  = flag.Int("snapshot.top-n", 0, "Export per-snapshot metrics for the N largest snapshots of each dataset (0 only exports per-dataset aggregates)")
+	snapshotLimit = flag.Int("snapshot.limit", 0, "Maximum number of snapshots enumerated per pool and scrape (0 for no limit), once it is reached no further datasets get snapshot metrics")
+)
+
+var (
+	datasetSnapshotCount     = prometheus.NewDesc("zfs_dataset_snapshot_count", "Number of snapshots of the dataset", []string{"name", "zpool"}, nil)
+	datasetSnapshotUsedBytes = prometheus.NewDesc("zfs_dataset_snapshot_used_bytes", "Sum of the space uniquely used by each of the dataset's snapshots", []string{"name", "zpool"}, nil)
+	snapshotUsedBytes        = prometheus.NewDesc("zfs_snapshot_used_bytes", "Space uniquely used by the snapshot", []string{"name", "dataset", "zpool"}, nil)
+	snapshotLimitReached     = prometheus.NewDesc("zfs_snapshot_limit_reached", "Whether snapshot enumeration stopped early because -snapshot.limit was reached", []string{"zpool"}, nil)
+)
+
+type snapshotUsage struct {
+	name string
+	used uint64
+}
+
+// collectSnapshots exports the snapshot metrics of all datasets of the given pool. Datasets whose
+// snapshots can't be listed are skipped, the last error is returned. Once -snapshot.limit is
+// reached the walk stops, the dataset whose snapshots were only partially enumerated doesn't get
+// any metrics as its count and sums would be wrong.
+func (c *zfsCollector) collectSnapshots(ch chan<- prometheus.Metric, pool *poolData) error {
+	poolName := pool.name
+	poolLabel := pool.label
+	var enumerated int
+	limitReached := false
+	var lastErr error
+	err := c.walkDatasets(poolName, func(dataset string, _ map[string]interface{}) bool {
+		if !datasetFilter.match(dataset) {
+			return true
+		}
+		var snapshots []snapshotUsage
+		var usedSum uint64
+		err := c.walkSnapshots(dataset, func(name string, props map[string]interface{}) bool {
+			if *snapshotLimit > 0 && enumerated >= *snapshotLimit {
+				limitReached = true
+				return false
+			}
+			enumerated++
+			used, _ := propUint64(props, "used")
+			usedSum += used
+			snapshots = append(snapshots, snapshotUsage{name: name, used: used})
+			return true
+		})
+		if err != nil {
+			c.scrapeErrors.WithLabelValues("snapshots").Inc()
+			level.Warn(logger).Log("msg", "Failed to list snapshots", "dataset", dataset, "err", err)
+			lastErr = err
+			return true
+		}
+		if limitReached {
+			return false
+		}
+		ch <- prometheus.MustNewConstMetric(datasetSnapshotCount, prometheus.GaugeValue, float64(len(snapshots)), dataset, poolLabel)
+		ch <- prometheus.MustNewConstMetric(datasetSnapshotUsedBytes, prometheus.GaugeValue, float64(usedSum), dataset, poolLabel)
+		if *snapshotTopN <= 0 {
+			return true
+		}
+		sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].used > snapshots[j].used })
+		if len(snapshots) > *snapshotTopN {
+			snapshots = snapshots[:*snapshotTopN]
+		}
+		for _, s := range snapshots {
+			ch <- prometheus.MustNewConstMetric(snapshotUsedBytes, prometheus.GaugeValue, float64(s.used), s.name, dataset, poolLabel)
+		}
+		return true
+	})
+	if err != nil {
+		c.scrapeErrors.WithLabelValues("snapshots").Inc()
+		level.Warn(logger).Log("msg", "Failed to list datasets", "pool", poolName, "err", err)
+		lastErr = err
+	}
+	var reached float64
+	if limitReached {
+		reached = 1
+	}
+	ch <- prometheus.MustNewConstMetric(snapshotLimitReached, prometheus.GaugeValue, reached, poolLabel)
+	return lastErr
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectSnapshotsLimit(t *testing.T) {
+	src := testPool(vdevStateHealthy)
+	src.datasets["testpool/other"] = map[string]interface{}{"used": prop(uint64(1 << 20))}
+	src.snapshots["testpool@a"] = map[string]interface{}{"used": prop(uint64(1))}
+	src.snapshots["testpool/data@hourly"] = map[string]interface{}{"used": prop(uint64(2))}
+	src.snapshots["testpool/other@a"] = map[string]interface{}{"used": prop(uint64(4))}
+
+	tests := []struct {
+		name  string
+		limit int
+		want  string
+	}{
+		{
+			name:  "unlimited",
+			limit: 0,
+			want: snapshotCountHeader + `zfs_dataset_snapshot_count{name="testpool",zpool="testpool"} 1
+zfs_dataset_snapshot_count{name="testpool/data",zpool="testpool"} 2
+zfs_dataset_snapshot_count{name="testpool/other",zpool="testpool"} 1
+` + snapshotLimitReachedHeader + `zfs_snapshot_limit_reached{zpool="testpool"} 0
+`,
+		},
+		{
+			// testpool/data has only been partially enumerated, testpool/other not at all.
+			name:  "limit reached",
+			limit: 2,
+			want: snapshotCountHeader + `zfs_dataset_snapshot_count{name="testpool",zpool="testpool"} 1
+` + snapshotLimitReachedHeader + `zfs_snapshot_limit_reached{zpool="testpool"} 1
+`,
+		},
+	}
+	defer func(limit int) { *snapshotLimit = limit }(*snapshotLimit)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*snapshotLimit = tt.limit
+			c := newCollector(src)
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.want), "zfs_dataset_snapshot_count", "zfs_snapshot_limit_reached"); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"git.dolansoft.org/lorenz/go-zfs/ioctl"
+)
+
+// zfsSource provides everything the collector reads through the ZFS control device. Tests
+// substitute canned nvlists for it.
+type zfsSource interface {
+	PoolConfigs() (map[string]interface{}, error)
+	PoolStats(poolName string) (map[string]interface{}, error)
+	PoolGetProps(poolName string) (map[string]interface{}, error)
+	// ObjsetStats returns the properties of a filesystem or volume.
+	ObjsetStats(name string) (map[string]interface{}, error)
+	// DatasetListNext and SnapshotListNext return the child dataset or snapshot following the
+	// given cookie together with its properties and the next cookie. They return ESRCH once
+	// there are no more.
+	DatasetListNext(name string, cookie uint64) (string, uint64, map[string]interface{}, error)
+	SnapshotListNext(name string, cookie uint64) (string, uint64, map[string]interface{}, error)
+	// ChannelProgram runs a channel program in open context (sync=false), so the kernel rejects
+	// any attempt to modify the pool.
+	ChannelProgram(poolName, program string, args map[string]interface{}) (map[string]interface{}, error)
+}
+
+// ioctlSource reads from the kernel through the ZFS control device.
+type ioctlSource struct{}
+
+func (ioctlSource) PoolConfigs() (map[string]interface{}, error) {
+	return ioctl.PoolConfigs()
+}
+
+func (ioctlSource) PoolStats(poolName string) (map[string]interface{}, error) {
+	return ioctl.PoolStats(poolName)
+}
+
+func (ioctlSource) PoolGetProps(poolName string) (map[string]interface{}, error) {
+	return ioctl.PoolGetProps(poolName)
+}
+
+func (ioctlSource) ObjsetStats(name string) (map[string]interface{}, error) {
+	_, props, err := ioctl.ObjsetStats(name)
+	return props, err
+}
+
+func (ioctlSource) DatasetListNext(name string, cookie uint64) (string, uint64, map[string]interface{}, error) {
+	child, next, _, props, err := ioctl.DatasetListNext(name, cookie)
+	return child, next, props, err
+}
+
+func (ioctlSource) SnapshotListNext(name string, cookie uint64) (string, uint64, map[string]interface{}, error) {
+	snapshot, next, _, props, err := ioctl.SnapshotListNext(name, cookie, true)
+	return snapshot, next, props, err
+}
+
+func (ioctlSource) ChannelProgram(poolName, program string, args map[string]interface{}) (map[string]interface{}, error) {
+	return ioctl.ChannelProgram(poolName, program, channelProgramInstrLimit, channelProgramMemLimit, false, args)
+}
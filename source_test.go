@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// fakeSource serves canned nvlists instead of talking to the kernel.
+type fakeSource struct {
+	configs  map[string]interface{}
+	stats    map[string]map[string]interface{}
+	statsErr error
+	props    map[string]map[string]interface{}
+	// datasets and snapshots are keyed by their full name, children are found by it.
+	datasets  map[string]map[string]interface{}
+	snapshots map[string]map[string]interface{}
+	programs  map[string]interface{} // channel program results by pool
+}
+
+var errNoSuchPool = errors.New("no such pool")
+
+func (s *fakeSource) PoolConfigs() (map[string]interface{}, error) {
+	return s.configs, nil
+}
+
+func (s *fakeSource) PoolStats(poolName string) (map[string]interface{}, error) {
+	if s.statsErr != nil {
+		return nil, s.statsErr
+	}
+	stats, ok := s.stats[poolName]
+	if !ok {
+		return nil, errNoSuchPool
+	}
+	return stats, nil
+}
+
+func (s *fakeSource) PoolGetProps(poolName string) (map[string]interface{}, error) {
+	props, ok := s.props[poolName]
+	if !ok {
+		return nil, errNoSuchPool
+	}
+	return props, nil
+}
+
+func (s *fakeSource) ObjsetStats(name string) (map[string]interface{}, error) {
+	props, ok := s.datasets[name]
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return props, nil
+}
+
+// listNext returns the entry at position cookie of the sorted names in m which are direct
+// children of parent, joined by sep.
+func listNext(m map[string]map[string]interface{}, parent, sep string, cookie uint64) (string, uint64, map[string]interface{}, error) {
+	var names []string
+	for name := range m {
+		if strings.HasPrefix(name, parent+sep) && !strings.ContainsAny(strings.TrimPrefix(name, parent+sep), "/@") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if cookie >= uint64(len(names)) {
+		return "", 0, nil, syscall.ESRCH
+	}
+	return names[cookie], cookie + 1, m[names[cookie]], nil
+}
+
+func (s *fakeSource) DatasetListNext(name string, cookie uint64) (string, uint64, map[string]interface{}, error) {
+	return listNext(s.datasets, name, "/", cookie)
+}
+
+func (s *fakeSource) SnapshotListNext(name string, cookie uint64) (string, uint64, map[string]interface{}, error) {
+	return listNext(s.snapshots, name, "@", cookie)
+}
+
+func (s *fakeSource) ChannelProgram(poolName, program string, args map[string]interface{}) (map[string]interface{}, error) {
+	ret, ok := s.programs[poolName]
+	if !ok {
+		return nil, syscall.ENOTSUP
+	}
+	return map[string]interface{}{"return": ret}, nil
+}
+
+// vdevStatsFor builds a vdev_stat_t array with the named stats set.
+func vdevStatsFor(values map[string]uint64) []uint64 {
+	last := vdevStats[len(vdevStats)-1]
+	raw := make([]uint64, last.offset+1)
+	for name, v := range values {
+		raw[vdevStats[vdevStatsByName[name]].offset] = v
+	}
+	return raw
+}
+
+// prop wraps a value like properties are returned by the kernel.
+func prop(v interface{}) map[string]interface{} {
+	return map[string]interface{}{"value": v}
+}
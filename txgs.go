@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("txg", scopePool, true, subCollectorFunc((*zfsCollector).collectTxgs))
+}
+
+// txgStateCommitted marks txgs in the txgs kstat which are completely synced
+const txgStateCommitted = "C"
+
+var (
+	txgNumber         = prometheus.NewDesc("zfs_pool_txg_last_committed", "Number of the most recently committed transaction group", []string{"zpool"}, nil)
+	txgDirtyBytes     = prometheus.NewDesc("zfs_pool_txg_dirty_bytes", "Dirty data of the most recently committed transaction group", []string{"zpool"}, nil)
+	txgWrittenBytes   = prometheus.NewDesc("zfs_pool_txg_written_bytes", "Bytes written by the most recently committed transaction group", []string{"zpool"}, nil)
+	txgOpenSeconds    = prometheus.NewDesc("zfs_pool_txg_open_duration_seconds", "Time the most recently committed transaction group was open", []string{"zpool"}, nil)
+	txgQuiesceSeconds = prometheus.NewDesc("zfs_pool_txg_quiesce_duration_seconds", "Time the most recently committed transaction group took to quiesce", []string{"zpool"}, nil)
+	txgWaitSeconds    = prometheus.NewDesc("zfs_pool_txg_wait_duration_seconds", "Time the most recently committed transaction group waited for the previous one to sync", []string{"zpool"}, nil)
+	txgSyncSeconds    = prometheus.NewDesc("zfs_pool_txg_sync_duration_seconds", "Time the most recently committed transaction group took to sync", []string{"zpool"}, nil)
+)
+
+// txgMetrics maps the columns of the txgs kstat to metrics. Times are in nanoseconds.
+var txgMetrics = []struct {
+	column  string
+	desc    *prometheus.Desc
+	divisor float64
+}{
+	{"txg", txgNumber, 1},
+	{"ndirty", txgDirtyBytes, 1},
+	{"nwritten", txgWrittenBytes, 1},
+	{"otime", txgOpenSeconds, 1e9},
+	{"qtime", txgQuiesceSeconds, 1e9},
+	{"wtime", txgWaitSeconds, 1e9},
+	{"stime", txgSyncSeconds, 1e9},
+}
+
+// collectTxgs exports the timings of the most recently committed txg from the pool's txgs kstat,
+// a table of the last zfs_txg_history transaction groups. The kstat is empty if that history
+// is disabled.
+func (c *zfsCollector) collectTxgs(ch chan<- prometheus.Metric, pool *poolData) error {
+	poolName := pool.name
+	last, err := readLastCommittedTxg(poolName)
+	if err != nil && !os.IsNotExist(err) {
+		c.scrapeErrors.WithLabelValues("kstat").Inc()
+		level.Warn(logger).Log("msg", "Failed to read txgs kstat", "pool", poolName, "err", err)
+		return err
+	}
+	if last == nil {
+		return nil
+	}
+	poolLabel := pool.label
+	for _, m := range txgMetrics {
+		if v, ok := last[m.column]; ok {
+			ch <- prometheus.MustNewConstMetric(m.desc, prometheus.GaugeValue, v/m.divisor, poolLabel)
+		}
+	}
+	return nil
+}
+
+// readLastCommittedTxg returns the columns of the last committed txg in the pool's txgs kstat,
+// or nil if there is none.
+func readLastCommittedTxg(poolName string) (map[string]float64, error) {
+	f, err := os.Open(filepath.Join(kstatRoot, poolName, "txgs"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseLastCommittedTxg(f)
+}
+
+// parseLastCommittedTxg returns the columns of the last committed txg in a txgs kstat.
+func parseLastCommittedTxg(r io.Reader) (map[string]float64, error) {
+	s := bufio.NewScanner(r)
+	// The first line is the kstat header, the second one the column names
+	var columns []string
+	for i := 0; i < 2 && s.Scan(); i++ {
+		columns = strings.Fields(s.Text())
+	}
+	stateIdx := -1
+	for i, col := range columns {
+		if col == "state" {
+			stateIdx = i
+		}
+	}
+	if stateIdx < 0 {
+		return nil, fmt.Errorf("unexpected columns %v", columns)
+	}
+	var last []string
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == len(columns) && fields[stateIdx] == txgStateCommitted {
+			last = fields
+		}
+	}
+	if err := s.Err(); err != nil || last == nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+	for i, col := range columns {
+		if v, err := strconv.ParseFloat(last[i], 64); err == nil {
+			values[col] = v
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLastCommittedTxg(t *testing.T) {
+	const header = `18 0 0x01 3 336 5396211284 2097396421768
+txg      birth            state ndirty       nread        nwritten     reads    writes   otime        qtime        wtime        stime
+`
+	tests := []struct {
+		name    string
+		kstat   string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{
+			name: "last committed",
+			kstat: header + `5461     2097386412345    C     1048576      0            2097152      0        12       5000061294   12345        23456        345678901
+5462     2097391412406    C     524288       4096         1572864      1        9        5000058012   23456        34567        234567890
+5463     2097396412464    S     0            0            0            0        0        5000066578   4321         54321        0
+5464     2097401412541    O     0            0            0            0        0        0            0            0            0
+`,
+			want: map[string]float64{
+				"txg": 5462, "birth": 2097391412406, "ndirty": 524288, "nread": 4096, "nwritten": 1572864,
+				"reads": 1, "writes": 9, "otime": 5000058012, "qtime": 23456, "wtime": 34567, "stime": 234567890,
+			},
+		},
+		{
+			name:  "history disabled",
+			kstat: header,
+		},
+		{
+			name: "nothing committed yet",
+			kstat: header + `1        2097401412541    O     0            0            0            0        0        0            0            0            0
+`,
+		},
+		{
+			name:    "unexpected columns",
+			kstat:   "18 0 0x01 3 336 5396211284 2097396421768\ntxg birth\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLastCommittedTxg(strings.NewReader(tt.kstat))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error: %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
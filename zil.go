@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("zil", scopeGlobal, true, kstatCollector{"zil", zilStats})
+}
+
+var zilStats = []kstatMetric{
+	{"zil_commit_count", prometheus.NewDesc("zfs_zil_commits_total", "Number of zil_commit calls, i.e. sync write requests", nil, nil), prometheus.CounterValue},
+	{"zil_commit_writer_count", prometheus.NewDesc("zfs_zil_commit_writers_total", "Number of zil_commit calls which wrote out the ZIL themselves", nil, nil), prometheus.CounterValue},
+	{"zil_itx_count", prometheus.NewDesc("zfs_zil_itxs_total", "Number of intent log transactions created", nil, nil), prometheus.CounterValue},
+	{"zil_itx_indirect_count", prometheus.NewDesc("zfs_zil_itx_indirect_total", "Number of write transactions whose data was written to the pool directly", nil, nil), prometheus.CounterValue},
+	{"zil_itx_indirect_bytes", prometheus.NewDesc("zfs_zil_itx_indirect_bytes_total", "Bytes of write transactions whose data was written to the pool directly", nil, nil), prometheus.CounterValue},
+	{"zil_itx_copied_count", prometheus.NewDesc("zfs_zil_itx_copied_total", "Number of write transactions whose data was copied into the log", nil, nil), prometheus.CounterValue},
+	{"zil_itx_copied_bytes", prometheus.NewDesc("zfs_zil_itx_copied_bytes_total", "Bytes of write transactions whose data was copied into the log", nil, nil), prometheus.CounterValue},
+	{"zil_itx_needcopy_count", prometheus.NewDesc("zfs_zil_itx_needcopy_total", "Number of write transactions whose data was copied into the log on commit", nil, nil), prometheus.CounterValue},
+	{"zil_itx_needcopy_bytes", prometheus.NewDesc("zfs_zil_itx_needcopy_bytes_total", "Bytes of write transactions whose data was copied into the log on commit", nil, nil), prometheus.CounterValue},
+	{"zil_itx_metaslab_normal_count", prometheus.NewDesc("zfs_zil_itx_metaslab_normal_total", "Number of log blocks written to the normal class", nil, nil), prometheus.CounterValue},
+	{"zil_itx_metaslab_normal_bytes", prometheus.NewDesc("zfs_zil_itx_metaslab_normal_bytes_total", "Bytes of log blocks written to the normal class", nil, nil), prometheus.CounterValue},
+	{"zil_itx_metaslab_slog_count", prometheus.NewDesc("zfs_zil_itx_metaslab_slog_total", "Number of log blocks written to dedicated log (SLOG) vdevs", nil, nil), prometheus.CounterValue},
+	{"zil_itx_metaslab_slog_bytes", prometheus.NewDesc("zfs_zil_itx_metaslab_slog_bytes_total", "Bytes of log blocks written to dedicated log (SLOG) vdevs", nil, nil), prometheus.CounterValue},
+}